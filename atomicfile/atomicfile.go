@@ -2,13 +2,58 @@ package atomicfile
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"zoneupdated/logging"
 )
 
+// Strategy controls how Commit moves the new contents into place.
+type Strategy int
+
+const (
+	// Rename the temp file over the original. Readers never see a partial file,
+	// but this fails when the original is a bind mount (EBUSY) or the temp file
+	// is on another filesystem (EXDEV).
+	Rename Strategy = iota
+	// Copy the temp file's contents over the original, then truncate it to
+	// their length. The original inode is preserved, so this works with
+	// bind-mounted files, but readers not honoring the lock may see a
+	// partially written file.
+	Copy
+	// Auto tries Rename first and falls back to Copy if the rename fails.
+	Auto
+)
+
+var strategyNames = map[Strategy]string{
+	Rename: "rename",
+	Copy:   "copy",
+	Auto:   "auto",
+}
+
+func (s Strategy) String() string {
+	name, ok := strategyNames[s]
+	if !ok {
+		return fmt.Sprintf("Strategy(%d)", int(s))
+	}
+	return name
+}
+
+func ParseStrategy(name string) (Strategy, error) {
+	for strategy, strategyName := range strategyNames {
+		if strings.EqualFold(name, strategyName) {
+			return strategy, nil
+		}
+	}
+
+	return Rename, fmt.Errorf("unknown commit strategy '%s'", name)
+}
+
 type AtomicFile struct {
 	fileName     string
 	tempFileName string
 	tempFile     *os.File
+	strategy     Strategy
 }
 
 const (
@@ -16,13 +61,17 @@ const (
 )
 
 func Open(filename string) (*AtomicFile, error) {
+	return OpenWithStrategy(filename, Rename)
+}
+
+func OpenWithStrategy(filename string, strategy Strategy) (*AtomicFile, error) {
 	tempFileName := fmt.Sprint(filename, TempSuffix)
 	tempFile, err := os.Create(tempFileName)
 	if err != nil {
 		return nil, err
 	}
 
-	return &AtomicFile{filename, tempFileName, tempFile}, nil
+	return &AtomicFile{filename, tempFileName, tempFile, strategy}, nil
 }
 
 func (a *AtomicFile) Write(p []byte) (n int, err error) {
@@ -45,7 +94,20 @@ func (a *AtomicFile) Abort() error {
 
 func (a *AtomicFile) Commit() error {
 	err := a.Close()
-	err2 := os.Rename(a.tempFileName, a.fileName)
+
+	var err2 error
+	switch a.strategy {
+	case Copy:
+		err2 = a.copyInPlace()
+	case Auto:
+		err2 = os.Rename(a.tempFileName, a.fileName)
+		if err2 != nil {
+			logging.Default().Warn("Rename failed, falling back to copying in place", "file", a.tempFileName, "error", err2)
+			err2 = a.copyInPlace()
+		}
+	default:
+		err2 = os.Rename(a.tempFileName, a.fileName)
+	}
 
 	if err2 != nil {
 		return err2
@@ -61,3 +123,46 @@ func (a *AtomicFile) Close() error {
 
 	return err
 }
+
+// copyInPlace writes the new contents over the original, and only then
+// truncates it to their length, so that the original is never left empty
+// while they are copied. If anything fails the original may be partly
+// written, so the temp file is kept and named in the error for recovery.
+func (a *AtomicFile) copyInPlace() error {
+	if err := a.writeInPlace(); err != nil {
+		return fmt.Errorf("copying the new contents into %s failed, and it may be partly written, "+
+			"but they are kept in %s: %s", a.fileName, a.tempFileName, err)
+	}
+
+	return os.Remove(a.tempFileName)
+}
+
+func (a *AtomicFile) writeInPlace() error {
+	source, err := os.Open(a.tempFileName)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	dest, err := os.OpenFile(a.fileName, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	written, err := io.Copy(dest, source)
+	if err == nil {
+		err = dest.Sync()
+	}
+	if err == nil {
+		err = dest.Truncate(written)
+	}
+	if err == nil {
+		err = dest.Sync()
+	}
+
+	err2 := dest.Close()
+	if err != nil {
+		return err
+	}
+	return err2
+}
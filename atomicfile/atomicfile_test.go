@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 	"zoneupdated/atomicfile"
 )
@@ -128,3 +129,109 @@ func testWrite(t *testing.T, a *atomicfile.AtomicFile, contents string) {
 		t.Fatalf("Error writing to AtomicFile: %s", err)
 	}
 }
+
+func TestAtomicFile_CommitCopy(t *testing.T) {
+	createOriginalFile(t, filename)
+	before, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("Error checking original file: %s", err)
+	}
+
+	a, err := atomicfile.OpenWithStrategy(filename, atomicfile.Copy)
+	if err != nil {
+		t.Fatalf("Error opening atomic file: %s", err)
+	}
+
+	testWrite(t, a, newContents)
+	err = a.Commit()
+	if err != nil {
+		t.Fatalf("Commit failed: %s", err)
+	}
+
+	checkFileMatches(t, filename, newContents)
+	checkFileDoesNotExist(t, tempFilename)
+
+	after, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("Error checking new file: %s", err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("Copy strategy should have updated the original file in place")
+	}
+}
+
+func TestAtomicFile_CommitCopyShorter(t *testing.T) {
+	createOriginalFile(t, filename)
+	a, err := atomicfile.OpenWithStrategy(filename, atomicfile.Copy)
+	if err != nil {
+		t.Fatalf("Error opening atomic file: %s", err)
+	}
+
+	testWrite(t, a, "Hi\n")
+	err = a.Commit()
+	if err != nil {
+		t.Fatalf("Commit failed: %s", err)
+	}
+
+	checkFileMatches(t, filename, "Hi\n")
+	checkFileDoesNotExist(t, tempFilename)
+}
+
+func TestAtomicFile_CommitCopyFailed(t *testing.T) {
+	dirname := filename + ".dir"
+	if err := os.Mkdir(dirname, 0755); err != nil {
+		t.Fatalf("Error creating directory: %s", err)
+	}
+	defer os.Remove(dirname)
+
+	// A directory can't be written over, so the new contents are kept
+	a, err := atomicfile.OpenWithStrategy(dirname, atomicfile.Copy)
+	if err != nil {
+		t.Fatalf("Error opening atomic file: %s", err)
+	}
+	defer os.Remove(dirname + atomicfile.TempSuffix)
+
+	testWrite(t, a, newContents)
+	err = a.Commit()
+	if err == nil {
+		t.Fatal("Commit should have failed")
+	}
+	if !strings.Contains(err.Error(), dirname+atomicfile.TempSuffix) {
+		t.Errorf("Expected the error to name the temp file but got: %s", err)
+	}
+
+	checkFileMatches(t, dirname+atomicfile.TempSuffix, newContents)
+}
+
+func TestAtomicFile_CommitAuto(t *testing.T) {
+	createOriginalFile(t, filename)
+	a, err := atomicfile.OpenWithStrategy(filename, atomicfile.Auto)
+	if err != nil {
+		t.Fatalf("Error opening atomic file: %s", err)
+	}
+
+	testWrite(t, a, newContents)
+	err = a.Commit()
+	if err != nil {
+		t.Fatalf("Commit failed: %s", err)
+	}
+
+	checkFileMatches(t, filename, newContents)
+	checkFileDoesNotExist(t, tempFilename)
+}
+
+func TestParseStrategy(t *testing.T) {
+	for _, strategy := range []atomicfile.Strategy{atomicfile.Rename, atomicfile.Copy, atomicfile.Auto} {
+		parsed, err := atomicfile.ParseStrategy(strategy.String())
+		if err != nil {
+			t.Errorf("Unable to parse strategy %s: %s", strategy, err)
+		} else if parsed != strategy {
+			t.Errorf("Parsing %s gave %s", strategy, parsed)
+		}
+	}
+
+	_, err := atomicfile.ParseStrategy("teleport")
+	if err == nil {
+		t.Error("Parsing an unknown strategy should have failed")
+	}
+}
@@ -0,0 +1,165 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Outcomes of changes
+const (
+	Changed   = "changed"
+	Unchanged = "unchanged"
+	NotFound  = "not_found"
+	Denied    = "denied"
+	Failed    = "error"
+)
+
+// Event is an attempt to change the records of a name and type. Old and New
+// are the values of the enabled records before and after the change.
+type Event struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Principal  string    `json:"principal,omitempty"`
+	ClientIP   string    `json:"client_ip,omitempty"`
+	Zone       string    `json:"zone"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Operation  string    `json:"operation"`
+	Old        []string  `json:"old"`
+	New        []string  `json:"new"`
+	Serial     uint32    `json:"serial,omitempty"`
+	DryRun     bool      `json:"dry_run,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs float64   `json:"duration_ms"`
+}
+
+// Request describes the HTTP request a change was made by.
+type Request struct {
+	ID       string
+	ClientIP string
+}
+
+type requestKey struct{}
+
+// NewContext returns a context carrying request.
+func NewContext(ctx context.Context, request Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, request)
+}
+
+// FromContext returns the request in ctx, if there is one.
+func FromContext(ctx context.Context) (Request, bool) {
+	request, ok := ctx.Value(requestKey{}).(Request)
+	return request, ok
+}
+
+// Log writes events to a file as JSON lines. When the file grows past maxSize
+// it is renamed with a .1 suffix, older ones being renamed to .2 and so on up
+// to backups, and a new one is started.
+type Log struct {
+	filename string
+	maxSize  int64
+	backups  int
+	mutex    sync.Mutex
+	file     *os.File
+	size     int64
+}
+
+// Open opens the log in filename, appending to it if it exists. A maxSize of
+// 0 means the log is never rotated.
+func Open(filename string, maxSize int64, backups int) (*Log, error) {
+	l := &Log{filename: filename, maxSize: maxSize, backups: backups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// Write appends event to the log, rotating it first if the event would take
+// it past the maximum size.
+func (l *Log) Write(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("while rotating: %s", err)
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+
+	if l.backups == 0 {
+		if err := os.Remove(l.filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for i := l.backups; i > 0; i-- {
+		from := l.filename
+		if i > 1 {
+			from = fmt.Sprintf("%s.%d", l.filename, i-1)
+		}
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", l.filename, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return l.open()
+}
+
+// Reopen closes and reopens the log file, so that it can be rotated by
+// something else, such as logrotate.
+func (l *Log) Reopen() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+
+	return l.open()
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.file.Close()
+}
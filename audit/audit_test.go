@@ -0,0 +1,77 @@
+package audit_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"zoneupdated/audit"
+)
+
+func TestLog_Rotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "audit.log")
+	l, err := audit.Open(filename, 200, 2)
+	if err != nil {
+		t.Fatalf("Unable to open log: %s", err)
+	}
+	defer l.Close()
+
+	// Each event is about 150 bytes, so each file holds one
+	for _, name := range []string{"one", "two", "three", "four"} {
+		if err := l.Write(audit.Event{Name: name, Outcome: audit.Changed}); err != nil {
+			t.Fatalf("Unable to write event: %s", err)
+		}
+	}
+
+	for suffix, name := range map[string]string{"": "four", ".1": "three", ".2": "two"} {
+		contents, err := ioutil.ReadFile(filename + suffix)
+		if err != nil {
+			t.Fatalf("Unable to read %s: %s", filename+suffix, err)
+		}
+		if strings.Count(string(contents), "\n") != 1 || !strings.Contains(string(contents), `"name":"`+name+`"`) {
+			t.Errorf("Expected %s in %s but got %s", name, filename+suffix, contents)
+		}
+	}
+
+	if _, err := os.Stat(filename + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 backups but got %s.3", filename)
+	}
+}
+
+func TestLog_Reopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "audit.log")
+	l, err := audit.Open(filename, 0, 0)
+	if err != nil {
+		t.Fatalf("Unable to open log: %s", err)
+	}
+	defer l.Close()
+
+	_ = l.Write(audit.Event{Name: "before"})
+
+	// As logrotate would
+	if err := os.Rename(filename, filename+".old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reopen(); err != nil {
+		t.Fatalf("Unable to reopen log: %s", err)
+	}
+	_ = l.Write(audit.Event{Name: "after"})
+
+	contents, _ := ioutil.ReadFile(filename)
+	if strings.Contains(string(contents), "before") || !strings.Contains(string(contents), "after") {
+		t.Errorf("Unexpected log after reopening: %s", contents)
+	}
+}
@@ -0,0 +1,252 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"zoneupdated/httperror"
+)
+
+// Operation is something a user may be allowed to do.
+type Operation string
+
+const (
+	// Present updates or re-enables existing records
+	Present Operation = "present"
+	// Cleanup disables or removes records
+	Cleanup Operation = "cleanup"
+	// Create adds new records
+	Create Operation = "create"
+	// Read looks at records
+	Read Operation = "read"
+	// Admin manages zoneupdated itself. It is never implied by a policy
+	// without ops, and must be given explicitly.
+	Admin Operation = "admin"
+)
+
+var operations = map[Operation]bool{Present: true, Cleanup: true, Create: true, Read: true, Admin: true}
+
+// Policy restricts which names, record types and operations a user may use,
+// and which networks the user may connect from. Names must match one of Allow
+// and none of Deny. An empty list doesn't restrict anything, except that Admin
+// must always be in Ops.
+type Policy struct {
+	Allow []string
+	Deny  []string
+	Types []string
+	Ops   []Operation
+	From  []*net.IPNet
+}
+
+// Principal is an authenticated user. A nil Policy allows everything but Admin.
+type Principal struct {
+	Name   string
+	Policy *Policy
+}
+
+type contextKey struct{}
+
+// ParsePolicy parses key=value fields, allow, deny, types, ops and from, each
+// with a comma separated list of values. It returns nil if there are no fields.
+func ParsePolicy(fields []string) (*Policy, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	policy := Policy{}
+	for _, field := range fields {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected key=value but got '%s'", field)
+		}
+
+		var values []string
+		for _, value := range strings.Split(parts[1], ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+
+		switch strings.ToLower(parts[0]) {
+		case "allow":
+			policy.Allow = append(policy.Allow, normalizeNames(values)...)
+		case "deny":
+			policy.Deny = append(policy.Deny, normalizeNames(values)...)
+		case "types":
+			for _, value := range values {
+				policy.Types = append(policy.Types, strings.ToUpper(value))
+			}
+		case "ops":
+			for _, value := range values {
+				op := Operation(strings.ToLower(value))
+				if !operations[op] {
+					return nil, fmt.Errorf("unknown operation '%s'", value)
+				}
+				policy.Ops = append(policy.Ops, op)
+			}
+		case "from":
+			for _, value := range values {
+				_, network, err := net.ParseCIDR(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid CIDR '%s'", value)
+				}
+				policy.From = append(policy.From, network)
+			}
+		default:
+			return nil, fmt.Errorf("unknown policy key '%s'", parts[0])
+		}
+	}
+
+	return &policy, nil
+}
+
+// Authorize checks whether the policy allows op on name and rrtype. An empty
+// op, name or rrtype isn't checked.
+func (policy *Policy) Authorize(op Operation, name string, rrtype string) error {
+	if op != "" && !policy.allowsOp(op) {
+		return httperror.WithCode(http.StatusForbidden, httperror.ForbiddenOperation,
+			fmt.Errorf("not allowed to %s", op))
+	}
+
+	if policy == nil {
+		return nil
+	}
+
+	if op == "" {
+		op = "use"
+	}
+
+	if rrtype != "" && len(policy.Types) > 0 && !contains(policy.Types, strings.ToUpper(rrtype)) {
+		return httperror.WithCode(http.StatusForbidden, httperror.ForbiddenType,
+			fmt.Errorf("not allowed to %s records of type %s", op, strings.ToUpper(rrtype)))
+	}
+
+	if name != "" && !policy.allowsName(normalizeName(name)) {
+		return httperror.WithCode(http.StatusForbidden, httperror.ForbiddenName,
+			fmt.Errorf("not allowed to %s %s", op, name))
+	}
+
+	return nil
+}
+
+// AllowedFrom checks whether the policy allows connecting from ip.
+func (policy *Policy) AllowedFrom(ip net.IP) bool {
+	if policy == nil || len(policy.From) == 0 {
+		return true
+	}
+
+	for _, network := range policy.From {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (policy *Policy) allowsOp(op Operation) bool {
+	if policy == nil || len(policy.Ops) == 0 {
+		return op != Admin
+	}
+
+	for _, allowed := range policy.Ops {
+		if allowed == op {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (policy *Policy) allowsName(name string) bool {
+	for _, pattern := range policy.Deny {
+		if MatchName(pattern, name) {
+			return false
+		}
+	}
+
+	if len(policy.Allow) == 0 {
+		return true
+	}
+
+	for _, pattern := range policy.Allow {
+		if MatchName(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchName checks whether name matches pattern. A pattern starting with a dot
+// matches that domain and any names under it, a pattern with * or ? is a glob
+// in which * also matches dots, and any other pattern matches only itself.
+// Both are compared without case or a trailing dot.
+func MatchName(pattern string, name string) bool {
+	pattern, name = normalizeName(pattern), normalizeName(name)
+
+	switch {
+	case strings.HasPrefix(pattern, "."):
+		return name == pattern[1:] || strings.HasSuffix(name, pattern)
+	case strings.ContainsAny(pattern, "*?["):
+		// path.Match's * doesn't match /, which can't be in a name
+		matched, err := path.Match(pattern, name)
+		return err == nil && matched
+	default:
+		return name == pattern
+	}
+}
+
+func NewContext(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// FromContext returns the authenticated user, if there is one.
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(contextKey{}).(Principal)
+	return principal, ok
+}
+
+// Authorize checks whether the user in ctx may do op on name and rrtype.
+// Everything but Admin is allowed if there is no authenticated user, as when
+// authentication isn't configured.
+func Authorize(ctx context.Context, op Operation, name string, rrtype string) error {
+	principal, _ := FromContext(ctx)
+	return principal.Policy.Authorize(op, name, rrtype)
+}
+
+// Restricted checks whether the user in ctx has a policy restricting names.
+func Restricted(ctx context.Context) bool {
+	principal, ok := FromContext(ctx)
+	return ok && principal.Policy != nil && (len(principal.Policy.Allow) > 0 || len(principal.Policy.Deny) > 0)
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func normalizeNames(names []string) []string {
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		if strings.HasPrefix(name, ".") {
+			normalized = append(normalized, "."+normalizeName(name[1:]))
+		} else {
+			normalized = append(normalized, normalizeName(name))
+		}
+	}
+
+	return normalized
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,116 @@
+package authz_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"zoneupdated/authz"
+	"zoneupdated/httperror"
+)
+
+func TestMatchName(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		matches bool
+	}{
+		{".example.com", "example.com.", true},
+		{".example.com", "_acme-challenge.www.example.com", true},
+		{".example.com", "badexample.com", false},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"host?.example.com", "host1.example.com", true},
+		{"www.example.com.", "WWW.Example.com", true},
+		{"www.example.com", "a.www.example.com", false},
+		{"*", "anything", true},
+	}
+
+	for _, test := range tests {
+		if authz.MatchName(test.pattern, test.name) != test.matches {
+			t.Errorf("Expected MatchName(%s, %s) to be %t", test.pattern, test.name, test.matches)
+		}
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := authz.ParsePolicy([]string{"allow=.example.com,www.example.org.", "deny=secret.example.com",
+		"types=txt", "ops=present,CLEANUP"})
+	if err != nil {
+		t.Fatalf("Unable to parse policy: %s", err)
+	}
+
+	if len(policy.Allow) != 2 || policy.Allow[1] != "www.example.org" || len(policy.Deny) != 1 ||
+		len(policy.Types) != 1 || policy.Types[0] != "TXT" || len(policy.Ops) != 2 || policy.Ops[1] != authz.Cleanup {
+		t.Errorf("Unexpected policy %+v", policy)
+	}
+
+	if policy, err = authz.ParsePolicy(nil); policy != nil || err != nil {
+		t.Errorf("Expected no policy but got %+v, %v", policy, err)
+	}
+
+	for _, fields := range [][]string{{"allow"}, {"ops=present,destroy"}, {"names=example.com"}, {"from=10.0.0.1"}} {
+		if _, err := authz.ParsePolicy(fields); err == nil {
+			t.Errorf("Expected policy %v to be invalid", fields)
+		}
+	}
+}
+
+func TestAllowedFrom(t *testing.T) {
+	policy, err := authz.ParsePolicy([]string{"from=10.0.0.0/8,2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("Unable to parse policy: %s", err)
+	}
+
+	for ip, allowed := range map[string]bool{"10.1.2.3": true, "2001:db8::1": true, "192.0.2.1": false, "": false} {
+		if policy.AllowedFrom(net.ParseIP(ip)) != allowed {
+			t.Errorf("Expected %s allowed to be %t", ip, allowed)
+		}
+	}
+
+	var unrestricted *authz.Policy
+	if !unrestricted.AllowedFrom(net.ParseIP("192.0.2.1")) {
+		t.Error("Expected no policy to allow any address")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	policy, _ := authz.ParsePolicy([]string{"allow=.example.com", "deny=secret.example.com", "types=TXT",
+		"ops=present,read"})
+	ctx := authz.NewContext(context.Background(), authz.Principal{Name: "user", Policy: policy})
+
+	tests := []struct {
+		op     authz.Operation
+		name   string
+		rrtype string
+		code   httperror.Code
+	}{
+		{authz.Present, "_acme-challenge.example.com.", "TXT", ""},
+		{authz.Read, "example.com", "txt", ""},
+		{authz.Cleanup, "_acme-challenge.example.com.", "TXT", httperror.ForbiddenOperation},
+		{authz.Present, "_acme-challenge.example.com.", "A", httperror.ForbiddenType},
+		{authz.Present, "example.org.", "TXT", httperror.ForbiddenName},
+		{authz.Present, "x.secret.example.com.", "TXT", ""},
+		{authz.Present, "secret.example.com.", "TXT", httperror.ForbiddenName},
+	}
+
+	for _, test := range tests {
+		err := authz.Authorize(ctx, test.op, test.name, test.rrtype)
+		if test.code == "" && err != nil {
+			t.Errorf("Expected %s of %s %s to be allowed but got %s", test.op, test.name, test.rrtype, err)
+		} else if test.code != "" && httperror.CodeOf(err) != test.code {
+			t.Errorf("Expected %s of %s %s to fail with %s but got %v", test.op, test.name, test.rrtype, test.code, err)
+		}
+	}
+
+	// Without a user or a policy, everything but admin is allowed
+	unrestricted := authz.NewContext(context.Background(), authz.Principal{Name: "user"})
+	for _, ctx := range []context.Context{context.Background(), unrestricted} {
+		if err := authz.Authorize(ctx, authz.Create, "example.com", "A"); err != nil {
+			t.Errorf("Expected create to be allowed but got %s", err)
+		}
+		if err := authz.Authorize(ctx, authz.Admin, "", ""); err == nil {
+			t.Error("Expected admin not to be allowed")
+		}
+	}
+}
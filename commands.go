@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"zoneupdated/authz"
+	"zoneupdated/config"
+	"zoneupdated/hmacauth"
+	"zoneupdated/tokens"
+	"zoneupdated/updater"
+)
+
+// Subcommands, run as eg "zoneupdated delegate ...". Each returns the exit status.
+var commands = map[string]func() int{
+	"delegate":    delegateCommand,
+	"token":       tokenCommand,
+	"sign":        signCommand,
+	"hmac-key":    hmacKeyCommand,
+	"healthcheck": healthcheckCommand,
+}
+
+func delegateCommand() int {
+	var rrtype string
+	var appendPlaceholders bool
+
+	conf, fqdns, err := config.InitCommand("delegate", "fqdn...", func() {
+		flag.StringVar(&rrtype, "rrtype", "TXT", "Record type of the placeholders")
+		flag.BoolVar(&appendPlaceholders, "append", false, "Append placeholders that don't exist yet to the zone file")
+	})
+	if err == nil && len(fqdns) == 0 {
+		flag.Usage()
+		err = fmt.Errorf("no fqdns given")
+	}
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	u := updater.New(conf)
+	delegations, result, err := u.Delegations(context.Background(), fqdns, rrtype, appendPlaceholders)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	for _, delegation := range delegations {
+		status := "add to"
+		if delegation.Appended {
+			status = "appended to"
+		} else if delegation.Exists {
+			status = "already in"
+		}
+
+		fmt.Printf("; %s\n", delegation.FQDN)
+		fmt.Printf("; add to the parent zone:\n%s\n", delegation.CNAME)
+		fmt.Printf("; %s the dynamic zone:\n%s\n\n", status, delegation.Placeholder)
+	}
+
+	if result.Changed {
+		fmt.Printf("; zone serial is now %d\n", result.Serial)
+	}
+
+	return 0
+}
+
+func tokenCommand() int {
+	var allow, deny, types, ops, allowFrom, description string
+	var expires time.Duration
+
+	conf, args, err := config.InitCommand("token", "issue|list|revoke [id]", func() {
+		flag.StringVar(&allow, "allow", "", "Comma separated names the token may use")
+		flag.StringVar(&deny, "deny", "", "Comma separated names the token may not use")
+		flag.StringVar(&types, "types", "", "Comma separated record types the token may use")
+		flag.StringVar(&ops, "ops", "", "Comma separated operations the token may do: present, cleanup, create, read, admin")
+		flag.StringVar(&allowFrom, "allow-from", "", "Comma separated CIDRs the token may be used from")
+		flag.StringVar(&description, "description", "", "What the token is for")
+		flag.DurationVar(&expires, "expires", 30*24*time.Hour, "How long until the token expires")
+	})
+	if err == nil && conf.TokenFile == "" {
+		err = fmt.Errorf("no --token-file given")
+	}
+	if err == nil && (len(args) == 0 || args[0] == "revoke" && len(args) != 2) {
+		flag.Usage()
+		err = fmt.Errorf("incorrect arguments")
+	}
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	store, err := tokens.New(conf.TokenFile)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	switch args[0] {
+	case "issue":
+		token := tokens.Token{
+			Description: description,
+			Allow:       splitList(allow),
+			Deny:        splitList(deny),
+			Types:       splitList(types),
+			AllowFrom:   splitList(allowFrom),
+			Expires:     time.Now().Add(expires),
+		}
+		for _, op := range splitList(ops) {
+			token.Ops = append(token.Ops, authz.Operation(op))
+		}
+
+		var secret string
+		token, secret, err = store.Issue(context.Background(), token)
+		if err == nil {
+			fmt.Printf("; token %s expires %s\n%s\n", token.ID, token.Expires.Format(time.RFC3339), secret)
+		}
+	case "list":
+		var list []tokens.Token
+		list, err = store.List()
+		if err == nil {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(list)
+		}
+	case "revoke":
+		err = store.Revoke(context.Background(), args[1])
+	default:
+		flag.Usage()
+		log.Printf("unknown token command %s", args[0])
+		return 2
+	}
+
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	return 0
+}
+
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// signCommand prints the Authorization header for a request signed with a
+// key from the --hmac-key-file, for use from shell scripts, eg
+// curl -H "$(zoneupdated sign ...)".
+func signCommand() int {
+	var keyID, secretFile, data, dataFile string
+
+	flag.StringVar(&keyID, "key-id", os.Getenv("ZUPD_HMAC_KEY_ID"), "The id of the key")
+	flag.StringVar(&secretFile, "secret-file", "", "A file containing the secret (default: $ZUPD_HMAC_SECRET)")
+	flag.StringVar(&data, "data", "", "The body of the request")
+	flag.StringVar(&dataFile, "data-file", "", "A file containing the body of the request, or - for stdin")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s sign [options] method url\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	_ = flag.CommandLine.Parse(os.Args[2:])
+
+	if flag.NArg() != 2 || keyID == "" {
+		flag.Usage()
+		return 2
+	}
+
+	secret := []byte(os.Getenv("ZUPD_HMAC_SECRET"))
+	if secretFile != "" {
+		contents, err := ioutil.ReadFile(secretFile)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		secret = []byte(strings.TrimSpace(string(contents)))
+	}
+	if len(secret) == 0 {
+		log.Print("no secret given")
+		return 2
+	}
+
+	body := []byte(data)
+	var err error
+	if dataFile == "-" {
+		body, err = ioutil.ReadAll(os.Stdin)
+	} else if dataFile != "" {
+		body, err = ioutil.ReadFile(dataFile)
+	}
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	// Only the path and query are signed, so a full URL may be given
+	target, err := url.Parse(flag.Arg(1))
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	header, err := hmacauth.Sign(keyID, secret, flag.Arg(0), target.RequestURI(), body, time.Now())
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	fmt.Printf("Authorization: %s\n", header)
+	return 0
+}
+
+// hmacKeyCommand prints a line for the --hmac-key-file with a new random
+// secret, and optionally a policy.
+func hmacKeyCommand() int {
+	if len(os.Args) < 3 || strings.HasPrefix(os.Args[2], "-") {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: %s hmac-key key-id [allow=... types=... ops=...]\n", os.Args[0])
+		return 2
+	}
+
+	if _, err := authz.ParsePolicy(os.Args[3:]); err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	fmt.Println(strings.Join(append([]string{os.Args[2], base64.RawURLEncoding.EncodeToString(b)}, os.Args[3:]...), " "))
+	return 0
+}
+
+// healthcheckCommand checks that a running daemon with the same options is
+// ready, or with --live just that it is running, for container health checks.
+func healthcheckCommand() int {
+	var live bool
+	var timeout time.Duration
+
+	conf, _, err := config.InitCommand("healthcheck", "", func() {
+		flag.BoolVar(&live, "live", false, "Only check that the daemon is running, not that it is ready")
+		flag.DurationVar(&timeout, "timeout", 5*time.Second, "How long to wait for the daemon to respond")
+	})
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	path := "/readyz"
+	if live {
+		path = "/healthz"
+	}
+
+	target, err := healthcheckURL(conf, path)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Get(target)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+	fmt.Print(string(body))
+
+	if response.StatusCode != http.StatusOK {
+		log.Printf("%s responded %s", target, response.Status)
+		return 1
+	}
+
+	return 0
+}
+
+// healthcheckURL returns the URL of path on the daemon's metrics listener,
+// the only one that serves the health checks.
+func healthcheckURL(conf config.Config, path string) (string, error) {
+	if conf.MetricsAddr == "" {
+		return "", fmt.Errorf("--metrics-listen is required, as the health checks are only served there")
+	}
+
+	host, port, err := net.SplitHostPort(conf.MetricsAddr)
+	if err != nil {
+		return "", fmt.Errorf("while parsing listen address: %s", err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+
+	target := url.URL{Scheme: "http", Host: net.JoinHostPort(host, port), Path: path}
+	return target.String(), nil
+}
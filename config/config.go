@@ -5,28 +5,94 @@ import (
 	"flag"
 	"fmt"
 	"github.com/jamiealquiza/envy"
+	"net"
 	"os"
 	"strings"
+	"zoneupdated/atomicfile"
+	"zoneupdated/logging"
 )
 
 type Config struct {
-	ZoneFileName     string
-	ListenAddr       string
-	HttpTimeoutSecs  int
-	HttpAuthRealm    string
-	HttpAuthFile     string
-	User             string
-	Password         string
-	TrustProxy       bool
-	TlsCertFilename  string
-	TlsKeyFilename   string
-	UrlPrefix        string
-	RobotsTxt        bool
-	TestMode         bool
-	SequentialSerial bool
+	ZoneFileName      string
+	ZoneName          string
+	ListenAddr        string
+	HttpTimeoutSecs   int
+	HttpAuthRealm     string
+	HttpAuthFile      string
+	HttpAuthPlaintext bool
+	TokenFile         string
+	User              string
+	Password          string
+	HttpAllowFrom     string
+	TrustProxy        bool
+	ProxyAuthHeader   string
+	ProxyAuthFrom     string
+	HmacKeyFile       string
+	HmacWindow        int
+	RateLimitIP       int
+	RateLimitUser     int
+	RateLimitName     int
+	LockoutFailures   int
+	LockoutWindow     int
+	LockoutDuration   int
+	JwtJwks           string
+	JwtIssuer         string
+	JwtAudience       string
+	JwtUserClaim      string
+	JwtNamesClaim     string
+	JwtTypesClaim     string
+	JwtOpsClaim       string
+	TlsCertFilename   string
+	TlsKeyFilename    string
+	TlsClientCA       string
+	TlsClientAuth     string
+	TlsClientIdentity string
+	UrlPrefix         string
+	RobotsTxt         bool
+	TestMode          bool
+	SequentialSerial  bool
+	CommitStrategy    string
+	HashAlgorithm     string
+	HashLength        int
+	HashLowercase     bool
+	HashSalt          string
+	HashNormalize     bool
+	HashAcceptLegacy  bool
+	AcmeDnsFile       string
+	DynDns2           bool
+	ExternalDnsAddr   string
+	MetricsAddr       string
+	IdempotencyDir    string
+	IdempotencyWindow int
+	AuditLog          string
+	AuditLogMaxSize   int
+	AuditLogBackups   int
+	LogLevel          string
+	LogFormat         string
 }
 
 func Init() (Config, error) {
+	config, args, err := parse(os.Args[1:], os.Args[0], "zone-file-name", nil)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if len(args) != 0 {
+		flag.Usage()
+		return Config{}, errors.New("incorrect arguments")
+	}
+
+	return config, nil
+}
+
+// InitCommand parses the arguments to a subcommand. Subcommands accept the same
+// options as the daemon, plus any registered by addFlags, followed by the zone
+// file name and then any further arguments, which are returned.
+func InitCommand(command string, argsUsage string, addFlags func()) (Config, []string, error) {
+	return parse(os.Args[2:], fmt.Sprint(os.Args[0], " ", command), fmt.Sprint("zone-file-name ", argsUsage), addFlags)
+}
+
+func parse(args []string, program string, argsUsage string, addFlags func()) (Config, []string, error) {
 	var config Config
 
 	flag.StringVar(&config.ListenAddr, "listen", ":8080", "Where to listen for HTTP(S) connections")
@@ -34,28 +100,77 @@ func Init() (Config, error) {
 	flag.StringVar(&config.HttpAuthRealm, "http-auth-realm", "zoneupdated", "Realm for HTTP Basic Auth")
 	flag.StringVar(&config.User, "http-user", "", "HTTP User to allow access")
 	flag.StringVar(&config.Password, "http-password", "", "HTTP Password to allow access")
-	flag.StringVar(&config.HttpAuthFile, "http-auth-file", "", "A file of users and passwords, plaintext, whitespace delimited")
+	flag.StringVar(&config.HttpAuthFile, "http-auth-file", "", "A file of users and password hashes, whitespace or colon (htpasswd) delimited")
+	flag.StringVar(&config.TokenFile, "token-file", "", "Accept bearer tokens, issued with the token subcommand or the admin API, from this file")
+	flag.BoolVar(&config.HttpAuthPlaintext, "http-auth-plaintext", false, "Allow plaintext passwords in the --http-auth-file")
+	flag.StringVar(&config.HttpAllowFrom, "http-allow-from", "", "Comma separated CIDRs that clients may connect from (default: anywhere)")
 	flag.BoolVar(&config.TrustProxy, "trust-proxy", false, "Trust X-Real-IP/X-Forwarded-For")
+	flag.StringVar(&config.ProxyAuthHeader, "proxy-auth-header", "", "Take the user from this header set by an authenticating proxy, eg X-Forwarded-User")
+	flag.StringVar(&config.ProxyAuthFrom, "proxy-auth-from", "", "Comma separated CIDRs of the proxies trusted to set --proxy-auth-header")
+	flag.StringVar(&config.HmacKeyFile, "hmac-key-file", "", "Accept requests signed with the shared secrets in this file")
+	flag.IntVar(&config.HmacWindow, "hmac-window", 300, "How many seconds the timestamps of signed requests may differ from the time")
+	flag.IntVar(&config.RateLimitIP, "rate-limit-ip", 0, "Requests a minute allowed from each client address (0 for no limit)")
+	flag.IntVar(&config.RateLimitUser, "rate-limit-user", 0, "Requests a minute allowed by each user (0 for no limit)")
+	flag.IntVar(&config.RateLimitName, "rate-limit-name", 0, "Changes a minute allowed to each record name (0 for no limit)")
+	flag.IntVar(&config.LockoutFailures, "lockout-failures", 0, "Authentication failures from a client address before it is locked out (0 to never lock out)")
+	flag.IntVar(&config.LockoutWindow, "lockout-window", 300, "Within how many seconds authentication failures count towards a lockout")
+	flag.IntVar(&config.LockoutDuration, "lockout-duration", 900, "For how many seconds client addresses are locked out")
+	flag.StringVar(&config.JwtJwks, "jwt-jwks", "", "Accept JWT bearer tokens signed by keys in this JWKS file or URL")
+	flag.StringVar(&config.JwtIssuer, "jwt-issuer", "", "The issuer (iss) JWTs must have")
+	flag.StringVar(&config.JwtAudience, "jwt-audience", "", "The audience (aud) JWTs must have")
+	flag.StringVar(&config.JwtUserClaim, "jwt-user-claim", "sub", "The JWT claim naming the user")
+	flag.StringVar(&config.JwtNamesClaim, "jwt-names-claim", "", "The JWT claim listing the names the user may change, instead of a policy in the --http-auth-file")
+	flag.StringVar(&config.JwtTypesClaim, "jwt-types-claim", "", "The JWT claim listing the record types the user may change")
+	flag.StringVar(&config.JwtOpsClaim, "jwt-ops-claim", "", "The JWT claim listing the operations the user may use")
 	flag.StringVar(&config.TlsCertFilename, "tls-cert", "", "TLS certificate chain file")
 	flag.StringVar(&config.TlsKeyFilename, "tls-key", "", "TLS certificate key file")
+	flag.StringVar(&config.TlsClientCA, "tls-client-ca", "", "CA certificates for authenticating clients by their certificates")
+	flag.StringVar(&config.TlsClientAuth, "tls-client-auth", "require", "Whether client certificates are required or optional")
+	flag.StringVar(&config.TlsClientIdentity, "tls-client-identity", "cn", "Client identity from the certificate's subject CN, or first SAN (san)")
 	flag.StringVar(&config.UrlPrefix, "url-prefix", "/zone-update", "URL prefix to serve")
 	flag.BoolVar(&config.RobotsTxt, "robots-txt", false, "Serve /robots.txt to block indexing")
+	flag.BoolVar(&config.DynDns2, "dyndns2", false, "Serve the dyndns2 protocol at /nic/update")
+	flag.StringVar(&config.ExternalDnsAddr, "external-dns-listen", "", "Serve the external-dns webhook provider API here, eg localhost:8888")
+	flag.StringVar(&config.MetricsAddr, "metrics-listen", "", "Serve Prometheus metrics at /metrics, and /healthz and /readyz, here, eg localhost:9100")
+	flag.StringVar(&config.AcmeDnsFile, "acme-dns-file", "", "Enable the acme-dns compatible API, saving accounts in this file")
+	flag.StringVar(&config.ZoneName, "zone-name", "", "Name of the zone (default: $ORIGIN from the zone file, or its file name)")
+	flag.StringVar(&config.HashAlgorithm, "hash-algorithm", "sha1", "Hash function for CNAME target labels: sha1 or sha256")
+	flag.IntVar(&config.HashLength, "hash-length", 0, "Truncate hashed labels to this many characters (0 for no truncation)")
+	flag.BoolVar(&config.HashLowercase, "hash-lowercase", false, "Use lowercase hashed labels")
+	flag.StringVar(&config.HashSalt, "hash-salt", "", "Secret salt (HMAC key) for hashed labels")
+	flag.BoolVar(&config.HashNormalize, "hash-normalize", false, "Lowercase FQDNs and add a trailing dot before hashing")
+	flag.BoolVar(&config.HashAcceptLegacy, "hash-accept-legacy", false, "Also accept labels hashed the original way, while migrating")
+	flag.StringVar(&config.IdempotencyDir, "idempotency-dir", "", "Where to remember responses for Idempotency-Key (default: zone file name + .idempotency, disabled if it can't be written)")
+	flag.IntVar(&config.IdempotencyWindow, "idempotency-window", 86400, "For how many seconds responses for Idempotency-Key are remembered (0 to disable)")
+	flag.StringVar(&config.AuditLog, "audit-log", "", "Write a JSON line for each change attempted to this file")
+	flag.IntVar(&config.AuditLogMaxSize, "audit-log-max-size", 100, "Rotate the audit log when it grows past this many megabytes (0 to never rotate)")
+	flag.IntVar(&config.AuditLogBackups, "audit-log-backups", 5, "How many rotated audit logs to keep")
+	flag.StringVar(&config.LogLevel, "log-level", "info", "Log messages at this level or above: debug, info, warn or error")
+	flag.StringVar(&config.LogFormat, "log-format", "text", "Log messages as text or json")
 	flag.BoolVar(&config.SequentialSerial, "sequential-serial", false, "Use a simple incrementing serial number (not date based)")
+	flag.StringVar(&config.CommitStrategy, "commit-strategy", "rename", "How to replace the zone file: rename, copy or auto")
 	flag.BoolVar(&config.TestMode, "test", false, "Testing Mode - Only update temp file")
 
 	envy.Parse("ZUPD") // Expose environment variables.
 
-	flag.Usage = usage
-	flag.Parse()
+	// Options specific to a subcommand aren't exposed as environment variables
+	if addFlags != nil {
+		addFlags()
+	}
+
+	flag.Usage = func() {
+		usage(program, argsUsage)
+	}
+	_ = flag.CommandLine.Parse(args)
 
-	if len(flag.Args()) != 1 {
+	if flag.NArg() < 1 {
 		flag.Usage()
-		return Config{}, errors.New("incorrect arguments")
+		return Config{}, nil, errors.New("incorrect arguments")
 	}
 
 	err := ValidateConfig(config)
 	if err != nil {
-		return Config{}, err
+		return Config{}, nil, err
 	}
 
 	if !strings.HasPrefix(config.UrlPrefix, "/") {
@@ -64,7 +179,7 @@ func Init() (Config, error) {
 
 	config.ZoneFileName = flag.Arg(0)
 
-	return config, nil
+	return config, flag.Args()[1:], nil
 }
 
 func ValidateConfig(config Config) error {
@@ -80,11 +195,116 @@ func ValidateConfig(config Config) error {
 		return errors.New("must supply both TLS cert AND key files or neither")
 	}
 
+	if config.TlsClientCA != "" && !config.UseHttps() {
+		return errors.New("client certificates need TLS cert and key files")
+	}
+
+	if (config.ProxyAuthHeader == "") != (config.ProxyAuthFrom == "") {
+		return errors.New("must supply both proxy auth header and the proxies it is trusted from or neither")
+	}
+
+	if err := validateCIDRs(config.ProxyAuthFrom); err != nil {
+		return fmt.Errorf("invalid proxy %s", err)
+	}
+
+	if err := validateCIDRs(config.HttpAllowFrom); err != nil {
+		return fmt.Errorf("invalid allowed %s", err)
+	}
+
+	if config.HmacKeyFile != "" && config.HmacWindow <= 0 {
+		return errors.New("the window for signed requests must be positive")
+	}
+
+	if config.RateLimitIP < 0 || config.RateLimitUser < 0 || config.RateLimitName < 0 || config.LockoutFailures < 0 {
+		return errors.New("rate limits and lockout failures can't be negative")
+	}
+
+	if config.LockoutFailures > 0 && (config.LockoutWindow <= 0 || config.LockoutDuration <= 0) {
+		return errors.New("lockout window and duration must be positive")
+	}
+
+	if config.JwtJwks != "" && (config.JwtIssuer == "" || config.JwtAudience == "") {
+		return errors.New("JWTs need an issuer and audience")
+	}
+
+	// Otherwise every subject the issuer signs for could change anything
+	if config.JwtJwks != "" && config.JwtNamesClaim == "" && config.HttpAuthFile == "" {
+		return errors.New("JWTs need a names claim or an auth file to give their users a policy")
+	}
+
+	if (config.JwtTypesClaim != "" || config.JwtOpsClaim != "") && config.JwtNamesClaim == "" {
+		return errors.New("JWT types and ops claims need a names claim")
+	}
+
+	switch strings.ToLower(config.TlsClientAuth) {
+	case "", "require", "optional":
+	default:
+		return fmt.Errorf("unknown client certificate authentication '%s', must be require or optional", config.TlsClientAuth)
+	}
+
+	switch strings.ToLower(config.TlsClientIdentity) {
+	case "", "cn", "san":
+	default:
+		return fmt.Errorf("unknown client identity '%s', must be cn or san", config.TlsClientIdentity)
+	}
+
+	switch strings.ToLower(config.HashAlgorithm) {
+	case "", "sha1", "sha256":
+	default:
+		return fmt.Errorf("unknown hash algorithm '%s'", config.HashAlgorithm)
+	}
+
+	// Labels are limited to 63 characters, and much less than 16 would risk collisions
+	if config.HashLength != 0 && (config.HashLength < 16 || config.HashLength > 63) {
+		return errors.New("hash length must be between 16 and 63, or 0 for the full hash")
+	}
+
+	if config.IdempotencyWindow < 0 {
+		return errors.New("idempotency window can't be negative")
+	}
+
+	if config.AuditLogMaxSize < 0 || config.AuditLogBackups < 0 {
+		return errors.New("audit log size and backups can't be negative")
+	}
+
+	if config.LogLevel != "" {
+		if _, err := logging.ParseLevel(config.LogLevel); err != nil {
+			return err
+		}
+	}
+
+	switch strings.ToLower(config.LogFormat) {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("unknown log format '%s', must be text or json", config.LogFormat)
+	}
+
+	if config.CommitStrategy != "" {
+		if _, err := atomicfile.ParseStrategy(config.CommitStrategy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateCIDRs checks a comma separated list of CIDRs, which may be empty.
+func validateCIDRs(cidrs string) error {
+	if cidrs == "" {
+		return nil
+	}
+
+	for _, cidr := range strings.Split(cidrs, ",") {
+		if _, _, err := net.ParseCIDR(strings.TrimSpace(cidr)); err != nil {
+			return fmt.Errorf("CIDR '%s'", cidr)
+		}
+	}
+
 	return nil
 }
 
-func usage() {
-	_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s zone-file-name\n\n", os.Args[0])
+func usage(program string, argsUsage string) {
+	_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] %s\n\n", program, argsUsage)
 	flag.PrintDefaults()
 }
 
@@ -57,3 +57,169 @@ func TestValidateConfig_TLS(t *testing.T) {
 		t.Errorf("No TLS cert and no TLS key should be allowed, but got %s", err)
 	}
 }
+
+func TestValidateConfig_TLSClient(t *testing.T) {
+	err := ValidateConfig(Config{TlsClientCA: "ca.pem"})
+	if err == nil {
+		t.Error("Client CA without TLS should have thrown an error")
+	}
+
+	tls := Config{TlsCertFilename: "cert.pem", TlsKeyFilename: "key.pem", TlsClientCA: "ca.pem"}
+	for _, mode := range []string{"require", "optional"} {
+		tls.TlsClientAuth = mode
+		if err = ValidateConfig(tls); err != nil {
+			t.Errorf("Client authentication %s should be allowed, but got %s", mode, err)
+		}
+	}
+
+	tls.TlsClientAuth = "sometimes"
+	if err = ValidateConfig(tls); err == nil {
+		t.Error("Unknown client authentication should have thrown an error")
+	}
+
+	tls.TlsClientAuth = ""
+	tls.TlsClientIdentity = "serial"
+	if err = ValidateConfig(tls); err == nil {
+		t.Error("Unknown client identity should have thrown an error")
+	}
+}
+
+func TestValidateConfig_ProxyAuth(t *testing.T) {
+	err := ValidateConfig(Config{ProxyAuthHeader: "X-Forwarded-User"})
+	if err == nil {
+		t.Error("Proxy auth header without trusted proxies should have thrown an error")
+	}
+
+	err = ValidateConfig(Config{ProxyAuthHeader: "X-Forwarded-User", ProxyAuthFrom: "10.0.0.0/8, ::1/128"})
+	if err != nil {
+		t.Errorf("Proxy auth should be allowed, but got %s", err)
+	}
+
+	err = ValidateConfig(Config{ProxyAuthHeader: "X-Forwarded-User", ProxyAuthFrom: "10.0.0.1"})
+	if err == nil {
+		t.Error("Invalid proxy CIDR should have thrown an error")
+	}
+}
+
+func TestValidateConfig_AllowFrom(t *testing.T) {
+	if err := ValidateConfig(Config{HttpAllowFrom: "10.0.0.0/8,192.0.2.0/24"}); err != nil {
+		t.Errorf("Allowed CIDRs should be allowed, but got %s", err)
+	}
+
+	if err := ValidateConfig(Config{HttpAllowFrom: "10.0.0.0/8,example.com"}); err == nil {
+		t.Error("Invalid allowed CIDR should have thrown an error")
+	}
+}
+
+func TestValidateConfig_AuditLog(t *testing.T) {
+	if err := ValidateConfig(Config{AuditLog: "audit.log", AuditLogMaxSize: 100, AuditLogBackups: 5}); err != nil {
+		t.Errorf("Audit log should be allowed, but got %s", err)
+	}
+
+	if err := ValidateConfig(Config{AuditLog: "audit.log", AuditLogMaxSize: -1}); err == nil {
+		t.Error("Negative audit log size should have thrown an error")
+	}
+}
+
+func TestValidateConfig_Logging(t *testing.T) {
+	if err := ValidateConfig(Config{LogLevel: "DEBUG", LogFormat: "json"}); err != nil {
+		t.Errorf("Log level and format should be allowed, but got %s", err)
+	}
+
+	if err := ValidateConfig(Config{LogLevel: "verbose"}); err == nil {
+		t.Error("Unknown log level should have thrown an error")
+	}
+
+	if err := ValidateConfig(Config{LogFormat: "xml"}); err == nil {
+		t.Error("Unknown log format should have thrown an error")
+	}
+}
+
+func TestValidateConfig_JWT(t *testing.T) {
+	err := ValidateConfig(Config{JwtJwks: "jwks.json", JwtIssuer: "https://issuer.example.com"})
+	if err == nil {
+		t.Error("JWKS without an audience should have thrown an error")
+	}
+
+	jwt := Config{JwtJwks: "jwks.json", JwtIssuer: "https://issuer.example.com", JwtAudience: "zoneupdated"}
+	if err = ValidateConfig(jwt); err == nil {
+		t.Error("JWTs without a names claim or auth file should have thrown an error")
+	}
+
+	jwt.HttpAuthFile = "passwd"
+	if err = ValidateConfig(jwt); err != nil {
+		t.Errorf("JWTs with an auth file should be allowed, but got %s", err)
+	}
+
+	jwt.HttpAuthFile = ""
+	jwt.JwtNamesClaim = "dns_names"
+	if err = ValidateConfig(jwt); err != nil {
+		t.Errorf("JWTs with a names claim should be allowed, but got %s", err)
+	}
+
+	jwt.JwtNamesClaim = ""
+	jwt.JwtOpsClaim = "dns_ops"
+	if err = ValidateConfig(jwt); err == nil {
+		t.Error("Ops claim without names claim should have thrown an error")
+	}
+}
+
+func TestValidateConfig_RateLimits(t *testing.T) {
+	err := ValidateConfig(Config{RateLimitName: -1})
+	if err == nil {
+		t.Error("Negative rate limit should have thrown an error")
+	}
+
+	err = ValidateConfig(Config{LockoutFailures: 5, LockoutWindow: 60})
+	if err == nil {
+		t.Error("Lockout without a duration should have thrown an error")
+	}
+
+	err = ValidateConfig(Config{RateLimitIP: 60, LockoutFailures: 5, LockoutWindow: 60, LockoutDuration: 60})
+	if err != nil {
+		t.Errorf("Rate limits should be allowed, but got %s", err)
+	}
+}
+
+func TestValidateConfig_CommitStrategy(t *testing.T) {
+	for _, strategy := range []string{"rename", "copy", "auto", "Auto", ""} {
+		err := ValidateConfig(Config{CommitStrategy: strategy})
+		if err != nil {
+			t.Errorf("Commit strategy '%s' should be allowed, but got %s", strategy, err)
+		}
+	}
+
+	err := ValidateConfig(Config{CommitStrategy: "teleport"})
+	if err == nil {
+		t.Error("Unknown commit strategy should have thrown an error")
+	}
+}
+
+func TestValidateConfig_Hash(t *testing.T) {
+	err := ValidateConfig(Config{HashAlgorithm: "sha256", HashLength: 32})
+	if err != nil {
+		t.Errorf("SHA-256 truncated to 32 should be allowed, but got %s", err)
+	}
+
+	err = ValidateConfig(Config{HashAlgorithm: "md5"})
+	if err == nil {
+		t.Error("Unknown hash algorithm should have thrown an error")
+	}
+
+	err = ValidateConfig(Config{HashLength: 8})
+	if err == nil {
+		t.Error("Very short hash length should have thrown an error")
+	}
+
+	err = ValidateConfig(Config{HashLength: 64})
+	if err == nil {
+		t.Error("Hash length longer than a label should have thrown an error")
+	}
+}
+
+func TestValidateConfig_IdempotencyWindow(t *testing.T) {
+	err := ValidateConfig(Config{IdempotencyWindow: -1})
+	if err == nil {
+		t.Error("Negative idempotency window should have thrown an error")
+	}
+}
@@ -1,12 +1,27 @@
 module zoneupdated
 
-go 1.14
+go 1.18
 
 require (
 	github.com/go-chi/chi v4.1.2+incompatible
 	github.com/gofrs/flock v0.7.1
 	github.com/jamiealquiza/envy v1.1.0
+	github.com/prometheus/client_golang v1.16.0
+	github.com/tsarna/chi v4.1.3-0.20200726164420-fb09d37b1acd+incompatible
+	golang.org/x/crypto v0.24.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
+	github.com/prometheus/client_model v0.3.0 // indirect
+	github.com/prometheus/common v0.42.0 // indirect
+	github.com/prometheus/procfs v0.10.1 // indirect
 	github.com/spf13/cobra v1.0.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
-	github.com/tsarna/chi v4.1.3-0.20200726164420-fb09d37b1acd+incompatible
+	golang.org/x/sys v0.21.0 // indirect
+	google.golang.org/protobuf v1.30.0 // indirect
 )
@@ -0,0 +1,147 @@
+package hmacauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheme is the Authorization scheme of signed requests, which look like
+//
+//	Authorization: ZU-HMAC-SHA256 key=<id>, ts=<unix time>, nonce=<nonce>, sig=<hex>
+//
+// where sig is the HMAC-SHA256 of StringToSign with the key's secret.
+const Scheme = "ZU-HMAC-SHA256"
+
+const (
+	minNonce = 8
+	maxNonce = 64
+)
+
+// Signature is a parsed Authorization header of a signed request.
+type Signature struct {
+	KeyID     string
+	Timestamp int64
+	Nonce     string
+	MAC       []byte
+}
+
+// StringToSign is what is signed for a request: the scheme, method, request
+// URI (the path and query), timestamp, nonce and hex SHA-256 of the body, on
+// separate lines.
+func StringToSign(method string, uri string, timestamp int64, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return strings.Join([]string{Scheme, strings.ToUpper(method), uri, strconv.FormatInt(timestamp, 10), nonce,
+		hex.EncodeToString(sum[:])}, "\n")
+}
+
+func mac(secret []byte, message string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(message))
+	return h.Sum(nil)
+}
+
+// Sign returns the Authorization header for a request signed at now, with a
+// random nonce.
+func Sign(keyID string, secret []byte, method string, uri string, body []byte, now time.Time) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(b)
+
+	sig := mac(secret, StringToSign(method, uri, now.Unix(), nonce, body))
+	return fmt.Sprintf("%s key=%s, ts=%d, nonce=%s, sig=%s", Scheme, keyID, now.Unix(), nonce, hex.EncodeToString(sig)), nil
+}
+
+// Parse parses the Authorization header of a signed request.
+func Parse(header string) (Signature, error) {
+	if len(header) <= len(Scheme) || !strings.EqualFold(header[:len(Scheme)+1], Scheme+" ") {
+		return Signature{}, fmt.Errorf("not a %s signature", Scheme)
+	}
+
+	var signature Signature
+	var err error
+	for _, param := range strings.Split(header[len(Scheme)+1:], ",") {
+		parts := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(parts) != 2 {
+			return Signature{}, fmt.Errorf("malformed signature parameter '%s'", param)
+		}
+
+		switch parts[0] {
+		case "key":
+			signature.KeyID = parts[1]
+		case "ts":
+			if signature.Timestamp, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+				return Signature{}, errors.New("malformed signature timestamp")
+			}
+		case "nonce":
+			signature.Nonce = parts[1]
+		case "sig":
+			if signature.MAC, err = hex.DecodeString(parts[1]); err != nil {
+				return Signature{}, errors.New("malformed signature")
+			}
+		}
+	}
+
+	if signature.KeyID == "" || signature.Timestamp == 0 || len(signature.MAC) == 0 {
+		return Signature{}, errors.New("signature needs key, ts and sig")
+	}
+	if len(signature.Nonce) < minNonce || len(signature.Nonce) > maxNonce {
+		return Signature{}, fmt.Errorf("signature nonce must be %d to %d characters", minNonce, maxNonce)
+	}
+
+	return signature, nil
+}
+
+// Verify checks the signature of a request with secret.
+func (signature Signature) Verify(secret []byte, method string, uri string, body []byte) bool {
+	expected := mac(secret, StringToSign(method, uri, signature.Timestamp, signature.Nonce, body))
+	return hmac.Equal(expected, signature.MAC)
+}
+
+// Replays remembers the nonces of signed requests, so that each is only
+// accepted once while its timestamp is within the window.
+type Replays struct {
+	window time.Duration
+	mutex  sync.Mutex
+	seen   map[string]time.Time
+}
+
+func NewReplays(window time.Duration) *Replays {
+	return &Replays{window: window, seen: make(map[string]time.Time)}
+}
+
+// Check checks that signature's timestamp is within the window of now, and
+// that its nonce hasn't been used before, and remembers it.
+func (replays *Replays) Check(signature Signature, now time.Time) error {
+	timestamp := time.Unix(signature.Timestamp, 0)
+	if timestamp.Before(now.Add(-replays.window)) || timestamp.After(now.Add(replays.window)) {
+		return fmt.Errorf("signature timestamp %s is outside the window", timestamp.UTC().Format(time.RFC3339))
+	}
+
+	replays.mutex.Lock()
+	defer replays.mutex.Unlock()
+
+	// Nonces can be forgotten once their timestamp is outside the window
+	for key, expires := range replays.seen {
+		if !now.Before(expires) {
+			delete(replays.seen, key)
+		}
+	}
+
+	key := signature.KeyID + " " + signature.Nonce
+	if _, ok := replays.seen[key]; ok {
+		return fmt.Errorf("replayed nonce %s", signature.Nonce)
+	}
+	replays.seen[key] = timestamp.Add(replays.window + time.Second)
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package hmacauth_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"zoneupdated/hmacauth"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSign(t *testing.T) {
+	now := time.Now()
+	header, err := hmacauth.Sign("ci", secret, "post", "/zone-update/present?dry_run=1", []byte(`{"fqdn": "test"}`), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature, err := hmacauth.Parse(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature.KeyID != "ci" || signature.Timestamp != now.Unix() || len(signature.Nonce) != 32 {
+		t.Errorf("Unexpected signature %+v", signature)
+	}
+
+	if !signature.Verify(secret, "POST", "/zone-update/present?dry_run=1", []byte(`{"fqdn": "test"}`)) {
+		t.Error("Expected signature to verify")
+	}
+
+	for name, request := range map[string][]string{
+		"method": {"PUT", "/zone-update/present?dry_run=1", `{"fqdn": "test"}`},
+		"path":   {"POST", "/zone-update/cleanup?dry_run=1", `{"fqdn": "test"}`},
+		"query":  {"POST", "/zone-update/present", `{"fqdn": "test"}`},
+		"body":   {"POST", "/zone-update/present?dry_run=1", `{"fqdn": "other"}`},
+	} {
+		if signature.Verify(secret, request[0], request[1], []byte(request[2])) {
+			t.Errorf("Expected signature with different %s not to verify", name)
+		}
+	}
+
+	if signature.Verify([]byte("another secret, entirely"), "POST", "/zone-update/present?dry_run=1", []byte(`{"fqdn": "test"}`)) {
+		t.Error("Expected signature with a different secret not to verify")
+	}
+}
+
+func TestStringToSign(t *testing.T) {
+	expected := "ZU-HMAC-SHA256\nPOST\n/zone-update/present\n1600000000\nabcdef0123456789\n" +
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if s := hmacauth.StringToSign("post", "/zone-update/present", 1600000000, "abcdef0123456789", nil); s != expected {
+		t.Errorf("Unexpected string to sign %q", s)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, header := range []string{
+		"Basic YWRtaW46YWRtaW5wdw==",
+		"ZU-HMAC-SHA256 key=ci, ts=1600000000, nonce=abcdef0123456789",
+		"ZU-HMAC-SHA256 key=ci, ts=soon, nonce=abcdef0123456789, sig=00",
+		"ZU-HMAC-SHA256 key=ci, ts=1600000000, nonce=abc, sig=00",
+		"ZU-HMAC-SHA256 key=ci, ts=1600000000, nonce=abcdef0123456789, sig=xyz",
+		"ZU-HMAC-SHA256 key=ci ts=1600000000",
+	} {
+		if _, err := hmacauth.Parse(header); err == nil {
+			t.Errorf("Expected %s to be refused", header)
+		}
+	}
+}
+
+func TestReplays(t *testing.T) {
+	replays := hmacauth.NewReplays(5 * time.Minute)
+	now := time.Now()
+
+	signature := hmacauth.Signature{KeyID: "ci", Timestamp: now.Unix(), Nonce: "abcdef0123456789"}
+	if err := replays.Check(signature, now); err != nil {
+		t.Errorf("Expected first use of nonce to be accepted: %s", err)
+	}
+	if err := replays.Check(signature, now.Add(time.Minute)); err == nil || !strings.Contains(err.Error(), "replayed") {
+		t.Errorf("Expected replay to be refused, but got %v", err)
+	}
+	if err := replays.Check(signature, now.Add(6*time.Minute)); err == nil {
+		t.Error("Expected replay after the window to be refused")
+	}
+
+	// The same nonce may be used by another key
+	other := hmacauth.Signature{KeyID: "other", Timestamp: now.Unix(), Nonce: "abcdef0123456789"}
+	if err := replays.Check(other, now); err != nil {
+		t.Errorf("Expected nonce of another key to be accepted: %s", err)
+	}
+
+	future := hmacauth.Signature{KeyID: "ci", Timestamp: now.Add(10 * time.Minute).Unix(), Nonce: "0123456789abcdef"}
+	if err := replays.Check(future, now); err == nil {
+		t.Error("Expected timestamp in the future to be refused")
+	}
+}
+
+func TestKeyFile(t *testing.T) {
+	keys, err := hmacauth.NewKeyFile("../restapi/testdata/hmac_keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, ok := keys.Lookup("ci")
+	if !ok || string(key.Secret) != string(secret) || key.Policy == nil || key.Policy.Allow[0] != "test.dyn.example.com" {
+		t.Errorf("Unexpected key %+v", key)
+	}
+
+	if _, ok = keys.Lookup("other"); ok {
+		t.Error("Expected no key other")
+	}
+}
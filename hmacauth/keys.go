@@ -0,0 +1,84 @@
+package hmacauth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"zoneupdated/authz"
+)
+
+// Key is a shared secret for signing requests.
+type Key struct {
+	ID     string
+	Secret []byte
+	// Policy restricts what the key may do, or is nil if it isn't restricted
+	Policy *authz.Policy
+}
+
+// KeyFile holds keys read from a file, one per line, as the key id and then
+// the secret delimited by whitespace, optionally followed by an authorization
+// policy.
+type KeyFile struct {
+	filename string
+	keys     atomic.Value
+}
+
+func NewKeyFile(filename string) (*KeyFile, error) {
+	k := KeyFile{filename: filename}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+
+	return &k, nil
+}
+
+// Lookup returns the key with id.
+func (k *KeyFile) Lookup(id string) (Key, bool) {
+	key, ok := k.keys.Load().(map[string]Key)[id]
+	return key, ok
+}
+
+func (k *KeyFile) Reload() error {
+	file, err := os.Open(k.filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	keys := make(map[string]Key)
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if len(fields) < 2 {
+			return fmt.Errorf("entry needs a key id and a secret at line %d", lineNumber)
+		}
+		if len(fields[1]) < 16 {
+			return fmt.Errorf("secret of %s at line %d is too short, use at least 16 characters", fields[0], lineNumber)
+		}
+
+		policy, err := authz.ParsePolicy(fields[2:])
+		if err != nil {
+			return fmt.Errorf("invalid policy at line %d: %s", lineNumber, err)
+		}
+
+		keys[fields[0]] = Key{ID: fields[0], Secret: []byte(fields[1]), Policy: policy}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	k.keys.Store(keys)
+
+	return nil
+}
@@ -1,13 +1,54 @@
 package httperror
 
+import (
+  "errors"
+  "net/http"
+)
+
+// Code is a stable, machine readable identifier for a kind of error.
+type Code string
+
+const (
+  RecordNotFound       Code = "RECORD_NOT_FOUND"
+  ZoneNotFound         Code = "ZONE_NOT_FOUND"
+  LockTimeout          Code = "LOCK_TIMEOUT"
+  InvalidValue         Code = "INVALID_VALUE"
+  InvalidRequest       Code = "INVALID_REQUEST"
+  Forbidden            Code = "FORBIDDEN"
+  ForbiddenName        Code = "FORBIDDEN_NAME"
+  ForbiddenType        Code = "FORBIDDEN_TYPE"
+  ForbiddenOperation   Code = "FORBIDDEN_OPERATION"
+  ForbiddenAddress     Code = "FORBIDDEN_ADDRESS"
+  Unauthorized         Code = "UNAUTHORIZED"
+  PreconditionFailed   Code = "PRECONDITION_FAILED"
+  Conflict             Code = "CONFLICT"
+  IdempotencyKeyReused Code = "IDEMPOTENCY_KEY_REUSED"
+  RateLimited          Code = "RATE_LIMITED"
+  LockedOut            Code = "LOCKED_OUT"
+  InternalError        Code = "INTERNAL_ERROR"
+)
+
+// Codes used for errors created without one
+var statusCodes = map[int]Code {
+  http.StatusBadRequest:         InvalidRequest,
+  http.StatusUnauthorized:       Unauthorized,
+  http.StatusForbidden:          Forbidden,
+  http.StatusNotFound:           RecordNotFound,
+  http.StatusConflict:           Conflict,
+  http.StatusPreconditionFailed: PreconditionFailed,
+  http.StatusTooManyRequests:    RateLimited,
+}
+
 type HttpError interface {
   error
   HttpStatus() int
+  Code() Code
 }
 
 type errorWithStatus struct {
   err        error
   httpStatus int
+  code       Code
 }
 
 func (err errorWithStatus) Error() string {
@@ -18,6 +59,43 @@ func (err errorWithStatus) HttpStatus() int {
   return err.httpStatus
 }
 
+func (err errorWithStatus) Code() Code {
+  return err.code
+}
+
+func (err errorWithStatus) Unwrap() error {
+  return err.err
+}
+
 func Error(httpStatus int, err error) HttpError {
-  return errorWithStatus { httpStatus: httpStatus, err: err }
+  code, ok := statusCodes[httpStatus]
+  if !ok {
+    code = InternalError
+  }
+
+  return WithCode(httpStatus, code, err)
+}
+
+func WithCode(httpStatus int, code Code, err error) HttpError {
+  return errorWithStatus { httpStatus: httpStatus, code: code, err: err }
+}
+
+// StatusOf returns the HTTP status for err, which is 500 unless it is an HttpError.
+func StatusOf(err error) int {
+  var httpError HttpError
+  if errors.As(err, &httpError) {
+    return httpError.HttpStatus()
+  }
+
+  return http.StatusInternalServerError
+}
+
+// CodeOf returns the error code for err, which is INTERNAL_ERROR unless it is an HttpError.
+func CodeOf(err error) Code {
+  var httpError HttpError
+  if errors.As(err, &httpError) {
+    return httpError.Code()
+  }
+
+  return InternalError
 }
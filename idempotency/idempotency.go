@@ -0,0 +1,189 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gofrs/flock"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"zoneupdated/atomicfile"
+	"zoneupdated/logging"
+)
+
+const (
+	recordSuffix = ".json"
+	lockSuffix   = ".lock"
+
+	// How often expired responses are removed
+	pruneInterval = time.Minute
+
+	// How many hex digits of a key's hash name its lock file
+	lockPrefixLength = 2
+)
+
+// Response is a response remembered for an idempotency key. Fingerprint
+// identifies the request it was the response to.
+type Response struct {
+	Fingerprint string    `json:"fingerprint"`
+	Created     time.Time `json:"created"`
+	Status      int       `json:"status"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+}
+
+// Store remembers responses by idempotency key in a directory, so that other
+// processes using the same directory see them too. Keys are locked with one of
+// a fixed set of lock files, so that a retry waits for the original request to
+// finish. Lock files are never removed, as a process could be waiting to lock
+// one that another removes, and then lock a new one created in its place.
+type Store struct {
+	dir       string
+	window    time.Duration
+	mutex     sync.Mutex
+	lastPrune time.Time
+}
+
+// Entry is the locked entry for a key in a Store.
+type Entry struct {
+	store    *Store
+	name     string
+	lockfile *flock.Flock
+}
+
+func New(dir string, window time.Duration) *Store {
+	return &Store{dir: dir, window: window}
+}
+
+// Check makes the store's directory if it doesn't exist, and checks that
+// responses can be saved in it.
+func (store *Store) Check() error {
+	if err := os.MkdirAll(store.dir, 0700); err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(store.dir, ".check")
+	if err != nil {
+		return err
+	}
+	_ = file.Close()
+
+	return os.Remove(file.Name())
+}
+
+// Lock locks the entry for key, which belongs to user, waiting until ctx is
+// done if another request with the same key is in progress.
+func (store *Store) Lock(ctx context.Context, user string, key string) (*Entry, error) {
+	if err := os.MkdirAll(store.dir, 0700); err != nil {
+		return nil, err
+	}
+
+	// Keys are chosen by clients, so they are hashed to make safe file names,
+	// and keys of different users don't collide
+	sum := sha256.Sum256([]byte(user + "\x00" + key))
+	name := filepath.Join(store.dir, hex.EncodeToString(sum[:]))
+
+	lockfile := flock.New(lockName(name))
+	success, err := lockfile.TryLockContext(ctx, 100*time.Millisecond)
+	if !success {
+		if err == nil {
+			err = fmt.Errorf("unknown error")
+		}
+		return nil, err
+	}
+
+	return &Entry{store: store, name: name, lockfile: lockfile}, nil
+}
+
+// Load returns the response remembered for the entry, if there is one that
+// hasn't expired.
+func (entry *Entry) Load() (Response, bool, error) {
+	data, err := ioutil.ReadFile(entry.name + recordSuffix)
+	if os.IsNotExist(err) {
+		return Response{}, false, nil
+	} else if err != nil {
+		return Response{}, false, err
+	}
+
+	var response Response
+	if err = json.Unmarshal(data, &response); err != nil {
+		return Response{}, false, fmt.Errorf("while parsing %s: %s", entry.name+recordSuffix, err)
+	}
+
+	if time.Since(response.Created) > entry.store.window {
+		return Response{}, false, nil
+	}
+
+	return response, true, nil
+}
+
+// Save remembers response for the entry.
+func (entry *Entry) Save(response Response) error {
+	file, err := atomicfile.Open(entry.name + recordSuffix)
+	if err != nil {
+		return err
+	}
+
+	if err = json.NewEncoder(file).Encode(response); err != nil {
+		_ = file.Abort()
+		return err
+	}
+
+	if err = file.Commit(); err != nil {
+		return err
+	}
+
+	entry.store.prune()
+	return nil
+}
+
+func (entry *Entry) Unlock() {
+	_ = entry.lockfile.Unlock()
+}
+
+// lockName is the name of the lock file for the entry with name, which is
+// shared with the entries whose hashes start the same way.
+func lockName(name string) string {
+	dir, base := filepath.Split(name)
+	return filepath.Join(dir, base[:lockPrefixLength]+lockSuffix)
+}
+
+// prune removes expired responses, at most once every pruneInterval.
+func (store *Store) prune() {
+	store.mutex.Lock()
+	if time.Since(store.lastPrune) < pruneInterval {
+		store.mutex.Unlock()
+		return
+	}
+	store.lastPrune = time.Now()
+	store.mutex.Unlock()
+
+	files, err := ioutil.ReadDir(store.dir)
+	if err != nil {
+		logging.Default().Warn("Unable to remove expired idempotency keys", "error", err)
+		return
+	}
+
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), recordSuffix) || time.Since(file.ModTime()) <= store.window {
+			continue
+		}
+
+		name := filepath.Join(store.dir, strings.TrimSuffix(file.Name(), recordSuffix))
+
+		// Entries in use are left for next time
+		lockfile := flock.New(lockName(name))
+		if locked, err := lockfile.TryLock(); err != nil || !locked {
+			continue
+		}
+
+		_ = os.Remove(name + recordSuffix)
+		_ = lockfile.Unlock()
+	}
+}
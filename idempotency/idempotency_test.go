@@ -0,0 +1,123 @@
+package idempotency_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"zoneupdated/idempotency"
+)
+
+func tempDir(t *testing.T) string {
+	return fmt.Sprintf("%s%c%d.%s", os.TempDir(), os.PathSeparator, os.Getpid(), t.Name())
+}
+
+func TestStore(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	store := idempotency.New(dir, time.Minute)
+
+	entry, err := store.Lock(context.TODO(), "user", "key")
+	if err != nil {
+		t.Fatalf("Locking entry failed: %s", err)
+	}
+
+	_, found, err := entry.Load()
+	if err != nil || found {
+		t.Errorf("New entry should be empty, got %v %v", found, err)
+	}
+
+	err = entry.Save(idempotency.Response{Fingerprint: "f", Created: time.Now(), Status: 200, Body: []byte("OK\n")})
+	if err != nil {
+		t.Fatalf("Saving response failed: %s", err)
+	}
+
+	// The entry is locked until it is unlocked
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err = store.Lock(ctx, "user", "key"); err == nil {
+		t.Error("Locking a locked entry should have timed out")
+	}
+	entry.Unlock()
+
+	// Another store using the same directory sees the response
+	entry, err = idempotency.New(dir, time.Minute).Lock(context.TODO(), "user", "key")
+	if err != nil {
+		t.Fatalf("Locking entry failed: %s", err)
+	}
+	response, found, err := entry.Load()
+	if err != nil || !found || response.Status != 200 || string(response.Body) != "OK\n" {
+		t.Errorf("Unexpected response %+v %v %v", response, found, err)
+	}
+	entry.Unlock()
+
+	// Keys are per user
+	entry, err = store.Lock(context.TODO(), "other", "key")
+	if err != nil {
+		t.Fatalf("Locking entry failed: %s", err)
+	}
+	if _, found, _ = entry.Load(); found {
+		t.Error("Another user's key should not be found")
+	}
+	entry.Unlock()
+}
+
+func TestStore_Expiry(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	store := idempotency.New(dir, time.Minute)
+
+	entry, err := store.Lock(context.TODO(), "", "key")
+	if err != nil {
+		t.Fatalf("Locking entry failed: %s", err)
+	}
+	defer entry.Unlock()
+
+	err = entry.Save(idempotency.Response{Created: time.Now().Add(-2 * time.Minute), Status: 200})
+	if err != nil {
+		t.Fatalf("Saving response failed: %s", err)
+	}
+
+	if _, found, _ := entry.Load(); found {
+		t.Error("Expired response should not be found")
+	}
+}
+
+func TestStore_Prune(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	save := func(store *idempotency.Store, key string) {
+		entry, err := store.Lock(context.TODO(), "user", key)
+		if err != nil {
+			t.Fatalf("Locking entry failed: %s", err)
+		}
+		defer entry.Unlock()
+
+		if err = entry.Save(idempotency.Response{Created: time.Now(), Status: 200}); err != nil {
+			t.Fatalf("Saving response failed: %s", err)
+		}
+	}
+
+	save(idempotency.New(dir, time.Minute), "old")
+
+	old := time.Now().Add(-2 * time.Minute)
+	responses, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	for _, response := range responses {
+		_ = os.Chtimes(response, old, old)
+	}
+
+	// Saving another response removes the expired one, but not its lock file,
+	// which may be about to be locked
+	save(idempotency.New(dir, time.Minute), "new")
+
+	responses, _ = filepath.Glob(filepath.Join(dir, "*.json"))
+	locks, _ := filepath.Glob(filepath.Join(dir, "*.lock"))
+	if len(responses) != 1 || len(locks) != 2 {
+		t.Errorf("Expected 1 response and 2 lock files but found %v and %v", responses, locks)
+	}
+}
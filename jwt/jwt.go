@@ -0,0 +1,270 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The signature algorithms supported, which are the ones OpenID Connect
+// providers commonly use.
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// Clock skew allowed when checking exp and nbf
+const leeway = time.Minute
+
+// Keys fetched from a URL are fetched again for a token signed with an unknown
+// key, in case they have been rotated, but no more often than this.
+const refetchInterval = time.Minute
+
+// Claims are the claims of a verified token.
+type Claims map[string]interface{}
+
+// Strings returns the values of a claim that is either a string or a list of
+// strings.
+func (claims Claims) Strings(name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func (claims Claims) time(name string) (time.Time, bool) {
+	value, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(value), 0), true
+}
+
+// Verifier verifies JWTs signed by keys from a JWKS file or URL, issued by
+// issuer for audience.
+type Verifier struct {
+	source   string
+	issuer   string
+	audience string
+	client   *http.Client
+
+	mutex   sync.RWMutex
+	keys    []Key
+	fetched time.Time
+}
+
+// NewVerifier loads the keys from source, which is a file name or an http or
+// https URL.
+func NewVerifier(source string, issuer string, audience string) (*Verifier, error) {
+	v := Verifier{source: source, issuer: issuer, audience: audience, client: &http.Client{Timeout: 10 * time.Second}}
+	if err := v.Reload(); err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+// Reload loads the keys again.
+func (v *Verifier) Reload() error {
+	data, err := v.read()
+	if err != nil {
+		return fmt.Errorf("while loading %s: %s", v.source, err)
+	}
+
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("while loading %s: %s", v.source, err)
+	}
+
+	v.mutex.Lock()
+	v.keys, v.fetched = keys, time.Now()
+	v.mutex.Unlock()
+
+	return nil
+}
+
+func (v *Verifier) isURL() bool {
+	return strings.HasPrefix(v.source, "https://") || strings.HasPrefix(v.source, "http://")
+}
+
+func (v *Verifier) read() ([]byte, error) {
+	if !v.isURL() {
+		return ioutil.ReadFile(v.source)
+	}
+
+	response, err := v.client.Get(v.source)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	return ioutil.ReadAll(io.LimitReader(response.Body, 1<<20))
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the signature, issuer, audience and expiry of token, and
+// returns its claims.
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed JWT")
+	}
+
+	var h header
+	if err := decodeJSON(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("invalid JWT header: %s", err)
+	}
+	if h.Alg != RS256 && h.Alg != ES256 {
+		return nil, fmt.Errorf("unsupported JWT algorithm %s", h.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("invalid JWT signature")
+	}
+
+	keys := v.candidates(h)
+	if len(keys) == 0 && v.refetch() {
+		keys = v.candidates(h)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no %s key %s", h.Alg, h.Kid)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	verified := false
+	for _, key := range keys {
+		if verify(key, digest[:], signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid JWT signature")
+	}
+
+	var claims Claims
+	if err = decodeJSON(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid JWT claims: %s", err)
+	}
+
+	if err = v.check(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// candidates returns the keys that may have signed a token with header h.
+func (v *Verifier) candidates(h header) []Key {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	var keys []Key
+	for _, key := range v.keys {
+		if key.Algorithm == h.Alg && (h.Kid == "" || key.ID == h.Kid) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// refetch loads the keys again if they come from a URL and haven't been
+// fetched recently, and reports whether it did.
+func (v *Verifier) refetch() bool {
+	v.mutex.RLock()
+	recent := time.Since(v.fetched) < refetchInterval
+	v.mutex.RUnlock()
+
+	if !v.isURL() || recent {
+		return false
+	}
+
+	return v.Reload() == nil
+}
+
+func verify(key Key, digest []byte, signature []byte) bool {
+	switch public := key.Public.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(public, crypto.SHA256, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		if len(signature) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(public, digest, r, s)
+	default:
+		return false
+	}
+}
+
+func (v *Verifier) check(claims Claims) error {
+	if issuer, _ := claims["iss"].(string); issuer != v.issuer {
+		return fmt.Errorf("unexpected JWT issuer %s", issuer)
+	}
+
+	audience := claims.Strings("aud")
+	found := false
+	for _, aud := range audience {
+		found = found || aud == v.audience
+	}
+	if !found {
+		return fmt.Errorf("unexpected JWT audience %s", strings.Join(audience, ", "))
+	}
+
+	now := time.Now()
+	expires, ok := claims.time("exp")
+	if !ok {
+		return errors.New("JWT has no expiry")
+	}
+	if !now.Before(expires.Add(leeway)) {
+		return errors.New("JWT has expired")
+	}
+
+	if notBefore, ok := claims.time("nbf"); ok && now.Add(leeway).Before(notBefore) {
+		return errors.New("JWT isn't valid yet")
+	}
+
+	return nil
+}
+
+func decodeJSON(part string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
@@ -0,0 +1,196 @@
+package jwt_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"zoneupdated/jwt"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(key.N.Bytes()),
+		"e": b64(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(key.X.FillBytes(make([]byte, 32))),
+		"y": b64(key.Y.FillBytes(make([]byte, 32)))}
+}
+
+func jwks(t *testing.T, keys ...map[string]string) []byte {
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func sign(t *testing.T, key crypto.Signer, kid string, claims map[string]interface{}) string {
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var signature []byte
+	var err error
+	if ecKey, ok := key.(*ecdsa.PrivateKey); ok {
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	} else if signature, err = key.Sign(rand.Reader, digest[:], crypto.SHA256); err != nil {
+		t.Fatal(err)
+	}
+
+	return input + "." + b64(signature)
+}
+
+func claims(overrides map[string]interface{}) map[string]interface{} {
+	c := map[string]interface{}{
+		"iss":       "https://issuer.example.com",
+		"aud":       "zoneupdated",
+		"sub":       "repo:example/site:ref:refs/heads/main",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"dns_names": []string{"_acme-challenge.www.example.com", "www.example.com"},
+	}
+	for name, value := range overrides {
+		if value == nil {
+			delete(c, name)
+		} else {
+			c[name] = value
+		}
+	}
+
+	return c
+}
+
+func TestVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := os.TempDir() + "/" + t.Name() + ".jwks"
+	defer os.Remove(filename)
+	if err = ioutil.WriteFile(filename, jwks(t, rsaJWK("rsa", rsaKey), ecJWK("ec", ecKey)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := jwt.NewVerifier(filename, "https://issuer.example.com", "zoneupdated")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []struct {
+		kid    string
+		signer crypto.Signer
+	}{{"rsa", rsaKey}, {"ec", ecKey}, {"", ecKey}} {
+		verified, err := verifier.Verify(sign(t, key.signer, key.kid, claims(nil)))
+		if err != nil {
+			t.Errorf("Expected token signed with %s to verify: %s", key.kid, err)
+		} else if names := verified.Strings("dns_names"); len(names) != 2 || names[1] != "www.example.com" {
+			t.Errorf("Unexpected dns_names %v", names)
+		}
+	}
+
+	invalid := map[string]string{
+		"wrong key":        sign(t, otherKey, "ec", claims(nil)),
+		"unknown key":      sign(t, ecKey, "other", claims(nil)),
+		"wrong issuer":     sign(t, ecKey, "ec", claims(map[string]interface{}{"iss": "https://evil.example.com"})),
+		"wrong audience":   sign(t, ecKey, "ec", claims(map[string]interface{}{"aud": []string{"other", "another"}})),
+		"expired":          sign(t, ecKey, "ec", claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"no expiry":        sign(t, ecKey, "ec", claims(map[string]interface{}{"exp": nil})),
+		"not yet valid":    sign(t, ecKey, "ec", claims(map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()})),
+		"malformed":        "not.a-jwt",
+		"unsigned":         b64([]byte(`{"alg":"none"}`)) + "." + b64([]byte(`{"iss":"https://issuer.example.com"}`)) + ".",
+		"tampered payload": strings.Replace(sign(t, ecKey, "ec", claims(nil)), ".", "."+b64([]byte(`{}`))+"x", 1),
+	}
+	for name, token := range invalid {
+		if _, err := verifier.Verify(token); err == nil {
+			t.Errorf("Expected %s token to be refused", name)
+		}
+	}
+
+	// An audience in a list is accepted
+	if _, err := verifier.Verify(sign(t, rsaKey, "rsa", claims(map[string]interface{}{"aud": []string{"other", "zoneupdated"}}))); err != nil {
+		t.Errorf("Expected audience in list to verify: %s", err)
+	}
+}
+
+func TestVerifier_URL(t *testing.T) {
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	keys := jwks(t, ecJWK("old", oldKey))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(keys)
+	}))
+	defer server.Close()
+
+	verifier, err := jwt.NewVerifier(server.URL, "https://issuer.example.com", "zoneupdated")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = verifier.Verify(sign(t, oldKey, "old", claims(nil))); err != nil {
+		t.Errorf("Expected token to verify: %s", err)
+	}
+
+	// Keys are only fetched again after a while, or when reloaded
+	keys = jwks(t, ecJWK("new", newKey))
+	if _, err = verifier.Verify(sign(t, newKey, "new", claims(nil))); err == nil {
+		t.Error("Expected token signed with new key to be refused until keys are fetched again")
+	}
+
+	if err = verifier.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = verifier.Verify(sign(t, newKey, "new", claims(nil))); err != nil {
+		t.Errorf("Expected token signed with new key to verify: %s", err)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	for _, data := range []string{
+		`{"keys": []}`,
+		`{"keys": [{"kty": "oct", "k": "c2VjcmV0"}]}`,
+		`{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`,
+		`not json`,
+	} {
+		if _, err := jwt.ParseJWKS([]byte(data)); err == nil {
+			t.Errorf("Expected JWKS %s to be refused", data)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level Level) String() string {
+	if level < LevelDebug || level > LevelError {
+		return strconv.Itoa(int(level))
+	}
+
+	return levelNames[level]
+}
+
+// ParseLevel parses the name of a level, such as info.
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("unknown log level '%s', must be debug, info, warn or error", name)
+}
+
+// Logger writes messages at or above a level, as text or JSON lines, with
+// fields given as alternating keys and values. Loggers made from each other
+// with With share their output.
+type Logger struct {
+	output *output
+	level  Level
+	fields []interface{}
+}
+
+type output struct {
+	mutex sync.Mutex
+	w     io.Writer
+	json  bool
+}
+
+// New returns a logger writing messages at level or above to w, as JSON lines
+// if asJSON is set and otherwise as text.
+func New(w io.Writer, level Level, asJSON bool) *Logger {
+	return &Logger{output: &output{w: w, json: asJSON}, level: level}
+}
+
+// With returns a logger that adds the fields in keyvals to every message.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(append(fields, l.fields...), keyvals...)
+
+	return &Logger{output: l.output, level: l.level, fields: fields}
+}
+
+// Enabled reports whether messages at level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LevelWarn, msg, keyvals)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+// Fatal logs msg as an error and exits.
+func (l *Logger) Fatal(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := append(append([]interface{}{}, l.fields...), keyvals...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "")
+	}
+
+	var line []byte
+	if l.output.json {
+		line = formatJSON(time.Now(), level, msg, fields)
+	} else {
+		line = formatText(time.Now(), level, msg, fields)
+	}
+
+	l.output.mutex.Lock()
+	defer l.output.mutex.Unlock()
+	_, _ = l.output.w.Write(line)
+}
+
+func formatText(now time.Time, level Level, msg string, fields []interface{}) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %-5s %s", now.UTC().Format("2006-01-02T15:04:05.000Z07:00"), strings.ToUpper(level.String()), msg)
+
+	for i := 0; i < len(fields); i += 2 {
+		value := fmt.Sprint(valueOf(fields[i+1]))
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&buf, " %s=%s", fields[i], value)
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func formatJSON(now time.Time, level Level, msg string, fields []interface{}) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	writeJSON(&buf, now.UTC().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSON(&buf, level.String())
+	buf.WriteString(`,"msg":`)
+	writeJSON(&buf, msg)
+
+	for i := 0; i < len(fields); i += 2 {
+		buf.WriteByte(',')
+		writeJSON(&buf, fmt.Sprint(fields[i]))
+		buf.WriteByte(':')
+		writeJSON(&buf, valueOf(fields[i+1]))
+	}
+
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+func writeJSON(buf *bytes.Buffer, value interface{}) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(encoded)
+}
+
+// valueOf is what to log for value. Errors and other Stringers are logged as
+// their strings, rather than as whatever JSON they might make.
+func valueOf(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	case time.Duration:
+		return v.String()
+	default:
+		return v
+	}
+}
+
+var (
+	defaultMutex  sync.RWMutex
+	defaultLogger = New(os.Stderr, LevelInfo, false)
+)
+
+// Default returns the logger for messages that aren't about a request.
+func Default() *Logger {
+	defaultMutex.RLock()
+	defer defaultMutex.RUnlock()
+
+	return defaultLogger
+}
+
+// SetDefault replaces the default logger.
+func SetDefault(l *Logger) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+
+	defaultLogger = l
+}
+
+type loggerKey struct{}
+
+// NewContext returns a context carrying l.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger in ctx, or the default logger if there is
+// none.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return l
+	}
+
+	return Default()
+}
+
+// Writer returns a writer that logs each line written to it at level, for
+// packages that use the log package.
+func (l *Logger) Writer(level Level) io.Writer {
+	return writer{logger: l, level: level}
+}
+
+type writer struct {
+	logger *Logger
+	level  Level
+}
+
+func (w writer) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.logger.log(w.level, line, nil)
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,69 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"zoneupdated/logging"
+)
+
+func TestLogger_Text(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, logging.LevelInfo, false).With("zone", "dyn.example.com.")
+
+	logger.Debug("Hidden")
+	logger.Info("Changed", "name", "test", "value", "two words", "error", errors.New("failed"))
+
+	line := buf.String()
+	if strings.Contains(line, "Hidden") {
+		t.Errorf("Debug message should not be logged at info: %s", line)
+	}
+	if !strings.Contains(line, ` INFO  Changed zone=dyn.example.com. name=test value="two words" error=failed`+"\n") {
+		t.Errorf("Unexpected message: %s", line)
+	}
+}
+
+func TestLogger_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, logging.LevelDebug, true).With("request_id", "abc")
+
+	logger.With("principal", "router").Warn("Refused", "status", 403, "error", errors.New("denied"))
+
+	var message map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &message); err != nil {
+		t.Fatalf("Invalid JSON %s: %s", buf.String(), err)
+	}
+	if message["level"] != "warn" || message["msg"] != "Refused" || message["request_id"] != "abc" ||
+		message["principal"] != "router" || message["status"] != float64(403) || message["error"] != "denied" ||
+		message["time"] == nil {
+		t.Errorf("Unexpected message %v", message)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if logging.FromContext(context.Background()) != logging.Default() {
+		t.Error("Expected the default logger without one in the context")
+	}
+
+	logger := logging.New(&bytes.Buffer{}, logging.LevelInfo, false)
+	if logging.FromContext(logging.NewContext(context.Background(), logger)) != logger {
+		t.Error("Expected the logger in the context")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, expected := range map[string]logging.Level{"debug": logging.LevelDebug, "WARN": logging.LevelWarn,
+		"error": logging.LevelError} {
+		level, err := logging.ParseLevel(name)
+		if err != nil || level != expected {
+			t.Errorf("Expected %s to be %s but got %s, %v", name, expected, level, err)
+		}
+	}
+
+	if _, err := logging.ParseLevel("verbose"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+}
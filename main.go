@@ -4,14 +4,38 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"zoneupdated/config"
+	"zoneupdated/logging"
 	"zoneupdated/restapi"
 	"zoneupdated/updater"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command())
+		}
+	}
+
 	conf, err := config.Init()
+	if err != nil {
+		logging.Default().Fatal(err.Error())
+	}
+
+	// Messages name the zone, or else the zone file, as one daemon serves each zone
+	zone := conf.ZoneName
+	if zone == "" {
+		zone = conf.ZoneFileName
+	}
+
+	// The level was already checked by config.ValidateConfig, and an empty one means info
+	level, _ := logging.ParseLevel(conf.LogLevel)
+	logger := logging.New(os.Stderr, level, strings.EqualFold(conf.LogFormat, "json")).With("zone", zone)
+	logging.SetDefault(logger)
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer(logging.LevelInfo))
 
 	api := restapi.New(conf, updater.New(conf))
 
@@ -20,17 +44,15 @@ func main() {
 	signal.Notify(sigchan, syscall.SIGHUP)
 	go func() {
 		for range sigchan {
-			log.Printf("Received SIGHUP, Reloading")
+			logger.Info("Received SIGHUP, reloading")
 			api.Reload()
 		}
 	}()
 
-	if err == nil {
-		err = api.ServeHttp()
-		if err != nil {
-			log.Fatal(err)
-		}
-	} else {
-		log.Fatal(err)
+	logger.Info("Starting", "commit_strategy", conf.CommitStrategy, "zone_file", conf.ZoneFileName)
+
+	err = api.ServeHttp()
+	if err != nil {
+		logger.Fatal(err.Error())
 	}
 }
@@ -0,0 +1,91 @@
+package passwd
+
+import (
+	"crypto/md5"
+	"errors"
+	"strings"
+)
+
+// MD5-crypt, as written by htpasswd by default ($apr1$) and by older versions
+// of crypt ($1$). The two differ only in their prefix.
+
+const (
+	apr1Prefix      = "$apr1$"
+	md5Prefix       = "$1$"
+	maxMd5CryptSalt = 8
+)
+
+// The order in which bytes of the digest are encoded, three at a time
+var md5Order = []int{0, 6, 12, 1, 7, 13, 2, 8, 14, 3, 9, 15, 4, 10, 5}
+
+func parseMd5Crypt(h string) (Hash, error) {
+	prefix := md5Prefix
+	if strings.HasPrefix(h, apr1Prefix) {
+		prefix = apr1Prefix
+	}
+
+	parts := strings.Split(h[len(prefix):], "$")
+	if len(parts) != 2 || len(parts[0]) > maxMd5CryptSalt || parts[1] == "" {
+		return nil, errors.New("invalid MD5-crypt hash")
+	}
+
+	salt := parts[0]
+	return cryptHash{hash: h, crypt: func(password string) string {
+		return md5Crypt(prefix, password, salt)
+	}}, nil
+}
+
+func md5Crypt(prefix string, password string, salt string) string {
+	p, s := []byte(password), []byte(salt)
+
+	alternate := md5.Sum([]byte(password + salt + password))
+
+	ctx := md5.New()
+	ctx.Write(p)
+	ctx.Write([]byte(prefix))
+	ctx.Write(s)
+	for n := len(p); n > 0; n -= md5.Size {
+		if n > md5.Size {
+			ctx.Write(alternate[:])
+		} else {
+			ctx.Write(alternate[:n])
+		}
+	}
+	for i := len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(p[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(p)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write(s)
+		}
+		if i%7 != 0 {
+			round.Write(p)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(p)
+		}
+		final = round.Sum(nil)
+	}
+
+	out := make([]byte, 0, 22)
+	for i := 0; i < len(md5Order); i += 3 {
+		out = encode24(out, final[md5Order[i]], final[md5Order[i+1]], final[md5Order[i+2]], 4)
+	}
+	out = encode24(out, 0, 0, final[11], 2)
+
+	return prefix + salt + "$" + string(out)
+}
@@ -0,0 +1,167 @@
+package passwd
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// ErrUnknownFormat is returned by Parse for a password that isn't in one of
+// the supported hash formats, which is presumably plaintext.
+var ErrUnknownFormat = errors.New("not a supported password hash")
+
+// Hash is a stored password, which passwords can be checked against.
+type Hash interface {
+	// Verify checks password against the hash, in constant time as far as
+	// anything about the hash is concerned.
+	Verify(password string) bool
+}
+
+// Parse parses a password hash in one of the formats written by htpasswd,
+// mkpasswd and similar tools: bcrypt ($2a$, $2b$ or $2y$), argon2id
+// ($argon2id$), SHA-crypt ($5$ or $6$), MD5-crypt ($apr1$ or $1$) or SHA-1
+// ({SHA}). As in /etc/shadow, * or a hash starting with ! locks the password,
+// so that it never matches.
+func Parse(hash string) (Hash, error) {
+	switch {
+	case hash == "*", strings.HasPrefix(hash, "!"):
+		return Locked{}, nil
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return parseBcrypt(hash)
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return parseArgon2id(hash)
+	case strings.HasPrefix(hash, sha256Prefix), strings.HasPrefix(hash, sha512Prefix):
+		return parseShaCrypt(hash)
+	case strings.HasPrefix(hash, apr1Prefix), strings.HasPrefix(hash, md5Prefix):
+		return parseMd5Crypt(hash)
+	case strings.HasPrefix(hash, shaPrefix):
+		return parseSha(hash)
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// Plaintext is a password stored as it is.
+type Plaintext string
+
+func (p Plaintext) Verify(password string) bool {
+	return subtle.ConstantTimeCompare([]byte(password), []byte(p)) == 1
+}
+
+// Locked is a password that can't be used.
+type Locked struct{}
+
+func (Locked) Verify(string) bool {
+	return false
+}
+
+type bcryptHash []byte
+
+func parseBcrypt(hash string) (Hash, error) {
+	// Cost checks the whole hash is well formed, not just the cost
+	if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		return nil, fmt.Errorf("invalid bcrypt hash: %s", err)
+	}
+
+	return bcryptHash(hash), nil
+}
+
+func (hash bcryptHash) Verify(password string) bool {
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+type argon2idHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2id parses the PHC string format used by the argon2 reference
+// implementation, $argon2id$v=19$m=65536,t=3,p=4$salt$key.
+func parseArgon2id(hash string) (Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2id version %s", parts[2])
+	}
+
+	var h argon2idHash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, fmt.Errorf("invalid argon2id parameters %s", parts[3])
+	}
+	if h.time == 0 || h.threads == 0 {
+		return nil, fmt.Errorf("invalid argon2id parameters %s", parts[3])
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id salt: %s", err)
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return nil, errors.New("invalid argon2id key")
+	}
+
+	return h, nil
+}
+
+func (hash argon2idHash) Verify(password string) bool {
+	key := argon2.IDKey([]byte(password), hash.salt, hash.time, hash.memory, hash.threads, uint32(len(hash.key)))
+	return subtle.ConstantTimeCompare(key, hash.key) == 1
+}
+
+const shaPrefix = "{SHA}"
+
+type shaHash []byte
+
+// parseSha parses the unsalted SHA-1 hashes written by htpasswd -s, which are
+// only supported for compatibility.
+func parseSha(hash string) (Hash, error) {
+	sum, err := base64.StdEncoding.DecodeString(hash[len(shaPrefix):])
+	if err != nil || len(sum) != sha1.Size {
+		return nil, errors.New("invalid {SHA} hash")
+	}
+
+	return shaHash(sum), nil
+}
+
+func (hash shaHash) Verify(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	return subtle.ConstantTimeCompare(sum[:], hash) == 1
+}
+
+// cryptHash is a hash in the traditional crypt format, which is checked by
+// hashing the password with the same salt and comparing the results.
+type cryptHash struct {
+	hash  string
+	crypt func(password string) string
+}
+
+func (hash cryptHash) Verify(password string) bool {
+	return subtle.ConstantTimeCompare([]byte(hash.crypt(password)), []byte(hash.hash)) == 1
+}
+
+// The alphabet of the base 64 encoding used by crypt
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// encode24 appends n characters encoding the 24 bits of b2, b1 and b0, least
+// significant first.
+func encode24(out []byte, b2 byte, b1 byte, b0 byte, n int) []byte {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for i := 0; i < n; i++ {
+		out = append(out, cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+
+	return out
+}
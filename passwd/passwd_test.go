@@ -0,0 +1,71 @@
+package passwd_test
+
+import (
+	"errors"
+	"testing"
+	"zoneupdated/passwd"
+)
+
+func TestParse_Verify(t *testing.T) {
+	tests := []struct {
+		hash     string
+		password string
+	}{
+		{"$2a$04$swLrX5JrFAtYnfKpjBZmnuh9lZcAcRVbRvwKz6SYzngVppx6aaVmG", "secret"},
+		{"$argon2id$v=19$m=64,t=1,p=1$c29tZXNhbHRzb21lc2FsdA$gC0w3wrF7JDJ1HGZ646yww", "secret"},
+		{"$apr1$Vb4Yzlt0$nsioSDxiBbm.j9PPioQHk.", "secret"},
+		{"$1$saltstri$YMyguxXMBpd2TEZ.vS/3q1", "Hello world!"},
+		{"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "secret"},
+		{"$5$abcdefgh$gruCpC7VkOTspMQTTSAR8mtlO9Upms.fwqE5y16JVM.", "secret"},
+		// Test vectors from the SHA-crypt specification
+		{"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world!"},
+		{"$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA", "Hello world!"},
+		{"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+			"Hello world!"},
+		{"$6$rounds=1400$anotherlongsalts$POfYwTEok97VWcjxIiSOjiykti.o/pQs.wPvMxQ6Fm7I6IoYN3CmLs66x9t0oSwbtEW7o7UmJEiDwGqd8p4ur1",
+			"a very much longer text to encrypt.  This one even stretches over morethan one line."},
+	}
+
+	for _, test := range tests {
+		hash, err := passwd.Parse(test.hash)
+		if err != nil {
+			t.Errorf("Unable to parse %s: %s", test.hash, err)
+			continue
+		}
+
+		if !hash.Verify(test.password) {
+			t.Errorf("Expected %s to match %s", test.password, test.hash)
+		}
+		if hash.Verify(test.password + "x") {
+			t.Errorf("Expected %sx not to match %s", test.password, test.hash)
+		}
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, hash := range []string{"$2a$04$tooshort", "$argon2id$v=19$m=64,t=1,p=1$c29tZXNhbHQ", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$5$rounds=many$salt$hash", "$6$saltthatiswaytoolong$hash", "$apr1$salt", "{SHA}notbase64!"} {
+		if _, err := passwd.Parse(hash); err == nil || errors.Is(err, passwd.ErrUnknownFormat) {
+			t.Errorf("Expected %s to be an invalid hash but got %v", hash, err)
+		}
+	}
+
+	if _, err := passwd.Parse("plaintext"); !errors.Is(err, passwd.ErrUnknownFormat) {
+		t.Errorf("Expected plaintext to be an unknown format but got %v", err)
+	}
+}
+
+func TestLocked(t *testing.T) {
+	for _, hash := range []string{"*", "!", "!$apr1$Vb4Yzlt0$nsioSDxiBbm.j9PPioQHk."} {
+		parsed, err := passwd.Parse(hash)
+		if err != nil || parsed.Verify("") || parsed.Verify("secret") {
+			t.Errorf("Expected %s to be locked", hash)
+		}
+	}
+}
+
+func TestPlaintext(t *testing.T) {
+	if !passwd.Plaintext("secret").Verify("secret") || passwd.Plaintext("secret").Verify("secre") {
+		t.Error("Unexpected plaintext comparison")
+	}
+}
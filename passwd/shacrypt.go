@@ -0,0 +1,159 @@
+package passwd
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// SHA-crypt, as specified in https://www.akkadia.org/drepper/SHA-crypt.txt
+
+const (
+	sha256Prefix = "$5$"
+	sha512Prefix = "$6$"
+
+	roundsPrefix     = "rounds="
+	defaultRounds    = 5000
+	minRounds        = 1000
+	maxRounds        = 999999999
+	maxShaCryptSalt  = 16
+	shaCryptHashSize = 86
+)
+
+// The order in which bytes of the digests are encoded, three at a time
+var (
+	sha256Order = []int{0, 10, 20, 21, 1, 11, 12, 22, 2, 3, 13, 23, 24, 4, 14, 15, 25, 5, 6, 16, 26, 27, 7, 17,
+		18, 28, 8, 9, 19, 29}
+	sha512Order = []int{0, 21, 42, 22, 43, 1, 44, 2, 23, 3, 24, 45, 25, 46, 4, 47, 5, 26, 6, 27, 48, 28, 49, 7,
+		50, 8, 29, 9, 30, 51, 31, 52, 10, 53, 11, 32, 12, 33, 54, 34, 55, 13, 56, 14, 35, 15, 36, 57, 37, 58, 16,
+		59, 17, 38, 18, 39, 60, 40, 61, 19, 62, 20, 41}
+)
+
+func parseShaCrypt(h string) (Hash, error) {
+	prefix := h[:3]
+	parts := strings.Split(h[3:], "$")
+
+	rounds := defaultRounds
+	customRounds := false
+	if len(parts) == 3 && strings.HasPrefix(parts[0], roundsPrefix) {
+		n, err := strconv.Atoi(parts[0][len(roundsPrefix):])
+		if err != nil {
+			return nil, fmt.Errorf("invalid SHA-crypt rounds %s", parts[0])
+		}
+		rounds = n
+		customRounds = true
+		parts = parts[1:]
+	}
+
+	if len(parts) != 2 || len(parts[0]) > maxShaCryptSalt || parts[1] == "" {
+		return nil, errors.New("invalid SHA-crypt hash")
+	}
+
+	salt := parts[0]
+	return cryptHash{hash: h, crypt: func(password string) string {
+		return shaCrypt(prefix, password, salt, rounds, customRounds)
+	}}, nil
+}
+
+func shaCrypt(prefix string, password string, salt string, rounds int, customRounds bool) string {
+	newHash, order := sha256.New, sha256Order
+	if prefix == sha512Prefix {
+		newHash, order = sha512.New, sha512Order
+	}
+
+	if rounds < minRounds {
+		rounds = minRounds
+	} else if rounds > maxRounds {
+		rounds = maxRounds
+	}
+
+	p, s := []byte(password), []byte(salt)
+
+	b := digest(newHash, p, s, p)
+
+	a := newHash()
+	a.Write(p)
+	a.Write(s)
+	a.Write(repeat(b, len(p)))
+	for i := len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			a.Write(b)
+		} else {
+			a.Write(p)
+		}
+	}
+	c := a.Sum(nil)
+
+	dp := newHash()
+	for range p {
+		dp.Write(p)
+	}
+	pBytes := repeat(dp.Sum(nil), len(p))
+
+	ds := newHash()
+	for i := 0; i < 16+int(c[0]); i++ {
+		ds.Write(s)
+	}
+	sBytes := repeat(ds.Sum(nil), len(s))
+
+	for i := 0; i < rounds; i++ {
+		round := newHash()
+		if i&1 != 0 {
+			round.Write(pBytes)
+		} else {
+			round.Write(c)
+		}
+		if i%3 != 0 {
+			round.Write(sBytes)
+		}
+		if i%7 != 0 {
+			round.Write(pBytes)
+		}
+		if i&1 != 0 {
+			round.Write(c)
+		} else {
+			round.Write(pBytes)
+		}
+		c = round.Sum(nil)
+	}
+
+	out := make([]byte, 0, shaCryptHashSize)
+	for i := 0; i < len(order); i += 3 {
+		out = encode24(out, c[order[i]], c[order[i+1]], c[order[i+2]], 4)
+	}
+	if prefix == sha512Prefix {
+		out = encode24(out, 0, 0, c[63], 2)
+	} else {
+		out = encode24(out, 0, c[31], c[30], 3)
+	}
+
+	result := prefix
+	if customRounds {
+		result += fmt.Sprintf("%s%d$", roundsPrefix, rounds)
+	}
+
+	return result + salt + "$" + string(out)
+}
+
+func digest(newHash func() hash.Hash, parts ...[]byte) []byte {
+	h := newHash()
+	for _, part := range parts {
+		h.Write(part)
+	}
+
+	return h.Sum(nil)
+}
+
+// repeat returns n bytes of b repeated.
+func repeat(b []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out)+len(b) <= n {
+		out = append(out, b...)
+	}
+
+	return append(out, b[:n-len(out)]...)
+}
@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"zoneupdated/httperror"
+)
+
+// Lockout locks out keys, such as a client's address or a user name, after
+// too many authentication failures within a window.
+type Lockout struct {
+	failures int
+	window   time.Duration
+	duration time.Duration
+	backoff  bool
+	mutex    sync.Mutex
+	entries  map[string]*lockoutEntry
+}
+
+type lockoutEntry struct {
+	failures    int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+// Locked is the state of a key with failures, for showing to administrators.
+type Locked struct {
+	Key         string     `json:"key"`
+	Failures    int        `json:"failures"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+// NewLockout returns a lockout that locks keys out for duration after failures
+// failures within window.
+func NewLockout(failures int, window time.Duration, duration time.Duration) *Lockout {
+	return &Lockout{failures: failures, window: window, duration: duration, entries: make(map[string]*lockoutEntry)}
+}
+
+// NewBackoff returns a lockout that, after failures failures within window,
+// locks keys out for a second after each further failure, doubling each time
+// up to max. It slows down guessing without locking a key out for long, for
+// keys such as user names that anyone could make fail.
+func NewBackoff(failures int, window time.Duration, max time.Duration) *Lockout {
+	return &Lockout{failures: failures, window: window, duration: max, backoff: true,
+		entries: make(map[string]*lockoutEntry)}
+}
+
+// Check returns an error if key is locked out.
+func (l *Lockout) Check(key string, now time.Time) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entry, ok := l.entries[key]
+	if !ok || !now.Before(entry.lockedUntil) {
+		return nil
+	}
+
+	reason := fmt.Sprintf("%s is locked out after too many authentication failures", key)
+	if l.backoff {
+		reason = fmt.Sprintf("%s is backing off after too many authentication failures", key)
+	}
+
+	return &Error{Reason: reason, RetryAfter: entry.lockedUntil.Sub(now), code: httperror.LockedOut}
+}
+
+// Fail records an authentication failure for key, and reports whether it is
+// now locked out.
+func (l *Lockout) Fail(key string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.prune(now)
+
+	entry, ok := l.entries[key]
+	if !ok || now.Sub(entry.first) > l.window {
+		entry = &lockoutEntry{first: now}
+		l.entries[key] = entry
+	}
+
+	entry.failures++
+	if entry.failures >= l.failures && l.backoff {
+		duration := l.duration
+		if shift := entry.failures - l.failures; shift < 32 && time.Second<<shift < duration {
+			duration = time.Second << shift
+		}
+		entry.lockedUntil = now.Add(duration)
+		return true
+	} else if entry.failures >= l.failures {
+		entry.lockedUntil = now.Add(l.duration)
+		entry.failures, entry.first = 0, now
+		return true
+	}
+
+	return false
+}
+
+// Reset forgets the failures of key, once it has authenticated, unless it is
+// locked out.
+func (l *Lockout) Reset(key string, now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if entry, ok := l.entries[key]; ok && !now.Before(entry.lockedUntil) {
+		delete(l.entries, key)
+	}
+}
+
+// prune forgets keys that aren't locked out and whose failures are outside
+// the window.
+func (l *Lockout) prune(now time.Time) {
+	for key, entry := range l.entries {
+		if now.Sub(entry.first) > l.window && !now.Before(entry.lockedUntil) {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// Locked returns the keys with recent failures or that are locked out.
+func (l *Lockout) Locked(now time.Time) []Locked {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.prune(now)
+
+	locked := []Locked{}
+	for key, entry := range l.entries {
+		state := Locked{Key: key, Failures: entry.failures}
+		if now.Before(entry.lockedUntil) {
+			until := entry.lockedUntil.UTC().Truncate(time.Second)
+			state.LockedUntil = &until
+		}
+		locked = append(locked, state)
+	}
+
+	sort.Slice(locked, func(i, j int) bool { return locked[i].Key < locked[j].Key })
+
+	return locked
+}
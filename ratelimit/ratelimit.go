@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+	"zoneupdated/httperror"
+)
+
+// Error is returned when a request is refused by a limiter or lockout.
+type Error struct {
+	Reason     string
+	RetryAfter time.Duration
+	code       httperror.Code
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("%s, retry after %d seconds", err.Reason, err.RetryAfterSeconds())
+}
+
+func (err *Error) HttpStatus() int {
+	return http.StatusTooManyRequests
+}
+
+func (err *Error) Code() httperror.Code {
+	return err.code
+}
+
+// RetryAfterSeconds is RetryAfter rounded up to whole seconds, for the
+// Retry-After header.
+func (err *Error) RetryAfterSeconds() int64 {
+	return int64(math.Ceil(err.RetryAfter.Seconds()))
+}
+
+// Limiter is a set of token buckets, one for each key, such as a client's
+// address or a user. Each bucket holds up to burst tokens, and is refilled at
+// a steady rate.
+type Limiter struct {
+	name      string
+	rate      float64
+	burst     float64
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Bucket is the state of a bucket, for showing to administrators.
+type Bucket struct {
+	Key    string  `json:"key"`
+	Tokens float64 `json:"tokens"`
+}
+
+// New returns a limiter allowing perMinute requests a minute for each key,
+// with bursts of up to a minute's worth. name describes what is limited, in
+// errors.
+func New(name string, perMinute int) *Limiter {
+	return &Limiter{
+		name:    name,
+		rate:    float64(perMinute) / 60,
+		burst:   float64(perMinute),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the bucket for key, or returns an error saying
+// when one will be available.
+func (l *Limiter) Allow(key string, now time.Time) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.refill(now, l.rate, l.burst)
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return &Error{Reason: fmt.Sprintf("too many requests for %s %s", l.name, key), RetryAfter: wait,
+			code: httperror.RateLimited}
+	}
+
+	b.tokens--
+	return nil
+}
+
+func (b *bucket) refill(now time.Time, rate float64, burst float64) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*rate)
+		b.updated = now
+	}
+}
+
+// prune forgets buckets that have filled up again, which are the same as new
+// ones, at most once a minute.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+
+	for key, b := range l.buckets {
+		b.refill(now, l.rate, l.burst)
+		if b.tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Buckets returns the buckets that aren't full, emptiest first.
+func (l *Limiter) Buckets(now time.Time) []Bucket {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	buckets := []Bucket{}
+	for key, b := range l.buckets {
+		b.refill(now, l.rate, l.burst)
+		if b.tokens < l.burst {
+			buckets = append(buckets, Bucket{Key: key, Tokens: math.Floor(b.tokens*100) / 100})
+		}
+	}
+
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Tokens < buckets[j].Tokens || buckets[i].Tokens == buckets[j].Tokens && buckets[i].Key < buckets[j].Key
+	})
+
+	return buckets
+}
@@ -0,0 +1,132 @@
+package ratelimit_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+	"zoneupdated/httperror"
+	"zoneupdated/ratelimit"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := ratelimit.New("client", 60)
+	now := time.Now()
+
+	for i := 0; i < 60; i++ {
+		if err := limiter.Allow("192.0.2.1", now); err != nil {
+			t.Fatalf("Expected request %d of the burst to be allowed: %s", i, err)
+		}
+	}
+
+	err := limiter.Allow("192.0.2.1", now)
+	var limited *ratelimit.Error
+	if !errors.As(err, &limited) || limited.RetryAfterSeconds() != 1 {
+		t.Fatalf("Expected to retry after a second, but got %v", err)
+	}
+	if httperror.StatusOf(err) != http.StatusTooManyRequests || httperror.CodeOf(err) != httperror.RateLimited {
+		t.Errorf("Unexpected status %d and code %s", httperror.StatusOf(err), httperror.CodeOf(err))
+	}
+
+	// Other keys have their own buckets
+	if err = limiter.Allow("192.0.2.2", now); err != nil {
+		t.Errorf("Expected another client to be allowed: %s", err)
+	}
+
+	// Buckets refill at the rate
+	if err = limiter.Allow("192.0.2.1", now.Add(time.Second)); err != nil {
+		t.Errorf("Expected request after a second to be allowed: %s", err)
+	}
+	if err = limiter.Allow("192.0.2.1", now.Add(time.Second)); err == nil {
+		t.Error("Expected second request after a second to be limited")
+	}
+
+	// The other client's bucket has filled up again
+	buckets := limiter.Buckets(now.Add(time.Second))
+	if len(buckets) != 1 || buckets[0].Key != "192.0.2.1" || buckets[0].Tokens != 0 {
+		t.Errorf("Unexpected buckets %+v", buckets)
+	}
+
+	if buckets = limiter.Buckets(now.Add(time.Hour)); len(buckets) != 0 {
+		t.Errorf("Expected full buckets not to be listed, but got %+v", buckets)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	lockout := ratelimit.NewLockout(3, time.Minute, 10*time.Minute)
+	now := time.Now()
+
+	// Failures outside the window don't count
+	lockout.Fail("user:bob", now.Add(-2*time.Minute))
+	lockout.Fail("user:bob", now)
+	if lockout.Fail("user:bob", now) {
+		t.Error("Expected two failures in the window not to lock out")
+	}
+	if err := lockout.Check("user:bob", now); err != nil {
+		t.Errorf("Expected not to be locked out yet: %s", err)
+	}
+
+	if !lockout.Fail("user:bob", now) {
+		t.Error("Expected third failure to lock out")
+	}
+
+	err := lockout.Check("user:bob", now.Add(time.Minute))
+	var limited *ratelimit.Error
+	if !errors.As(err, &limited) || limited.RetryAfterSeconds() != 540 || httperror.CodeOf(err) != httperror.LockedOut {
+		t.Errorf("Expected to be locked out for 9 more minutes, but got %v", err)
+	}
+
+	// Authenticating doesn't end a lockout
+	lockout.Reset("user:bob", now.Add(time.Minute))
+	if err = lockout.Check("user:bob", now.Add(time.Minute)); err == nil {
+		t.Error("Expected to still be locked out")
+	}
+
+	locked := lockout.Locked(now.Add(time.Minute))
+	if len(locked) != 1 || locked[0].Key != "user:bob" || locked[0].LockedUntil == nil {
+		t.Errorf("Unexpected lockouts %+v", locked)
+	}
+
+	if err = lockout.Check("user:bob", now.Add(10*time.Minute)); err != nil {
+		t.Errorf("Expected lockout to have ended: %s", err)
+	}
+
+	// but does forget failures
+	lockout.Fail("user:alice", now)
+	lockout.Fail("user:alice", now)
+	lockout.Reset("user:alice", now)
+	if lockout.Fail("user:alice", now) {
+		t.Error("Expected failures to have been forgotten")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	backoff := ratelimit.NewBackoff(2, 5*time.Minute, 4*time.Second)
+	now := time.Now()
+
+	if backoff.Fail("user:bob", now) {
+		t.Error("Expected one failure not to back off")
+	}
+
+	// Each failure after that backs off twice as long, up to the maximum
+	for i, seconds := range []int64{1, 2, 4, 4} {
+		if !backoff.Fail("user:bob", now) {
+			t.Errorf("Expected failure %d to back off", i+2)
+		}
+
+		err := backoff.Check("user:bob", now)
+		var limited *ratelimit.Error
+		if !errors.As(err, &limited) || limited.RetryAfterSeconds() != seconds || httperror.CodeOf(err) != httperror.LockedOut {
+			t.Errorf("Expected to back off for %d seconds, but got %v", seconds, err)
+		}
+	}
+
+	if err := backoff.Check("user:bob", now.Add(4*time.Second)); err != nil {
+		t.Errorf("Expected backoff to have ended: %s", err)
+	}
+
+	backoff.Reset("user:bob", now.Add(4*time.Second))
+	if backoff.Fail("user:bob", now.Add(4*time.Second)) {
+		t.Error("Expected failures to have been forgotten")
+	}
+}
@@ -0,0 +1,339 @@
+package restapi
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"zoneupdated/atomicfile"
+	"zoneupdated/logging"
+	"zoneupdated/updater"
+)
+
+const (
+	// acme-dns keeps the two most recent values, so that certificates covering
+	// both a domain and its wildcard can be validated together
+	acmeDnsValuesKept = 2
+	acmeDnsTxtLength  = 43
+)
+
+// AcmeDnsAccount is an account registered through the acme-dns compatible API.
+// Each has its own subdomain, whose TXT records it may update.
+type AcmeDnsAccount struct {
+	Username  string   `json:"username"`
+	KeyHash   string   `json:"key_hash"`
+	Subdomain string   `json:"subdomain"`
+	AllowFrom []string `json:"allowfrom"`
+}
+
+// AcmeDnsAccounts keeps track of acme-dns accounts, saving them to a JSON file.
+type AcmeDnsAccounts struct {
+	filename string
+	mutex    sync.RWMutex
+	accounts map[string]AcmeDnsAccount
+}
+
+type acmeDnsRegisterRequest struct {
+	AllowFrom []string `json:"allowfrom"`
+}
+
+type acmeDnsRegisterResponse struct {
+	Username   string   `json:"username"`
+	Password   string   `json:"password"`
+	FullDomain string   `json:"fulldomain"`
+	Subdomain  string   `json:"subdomain"`
+	AllowFrom  []string `json:"allowfrom"`
+}
+
+type acmeDnsUpdateRequest struct {
+	Subdomain string `json:"subdomain"`
+	Txt       string `json:"txt"`
+}
+
+func NewAcmeDnsAccounts(filename string) (*AcmeDnsAccounts, error) {
+	a := AcmeDnsAccounts{filename: filename, accounts: make(map[string]AcmeDnsAccount)}
+
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return &a, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var accounts []AcmeDnsAccount
+	if err = json.NewDecoder(file).Decode(&accounts); err != nil {
+		return nil, fmt.Errorf("while parsing %s: %s", filename, err)
+	}
+
+	for _, account := range accounts {
+		a.accounts[account.Username] = account
+	}
+
+	return &a, nil
+}
+
+// Check returns the account for username if key is correct.
+func (a *AcmeDnsAccounts) Check(username string, key string) (AcmeDnsAccount, bool) {
+	a.mutex.RLock()
+	account, ok := a.accounts[username]
+	a.mutex.RUnlock()
+
+	keyHash := hashAcmeDnsKey(key)
+	if !ok || subtle.ConstantTimeCompare([]byte(keyHash), []byte(account.KeyHash)) != 1 {
+		return AcmeDnsAccount{}, false
+	}
+
+	return account, true
+}
+
+// Add saves a new account.
+func (a *AcmeDnsAccounts) Add(account AcmeDnsAccount) error {
+	return a.change(func(accounts map[string]AcmeDnsAccount) {
+		accounts[account.Username] = account
+	})
+}
+
+// Remove removes the account for username.
+func (a *AcmeDnsAccounts) Remove(username string) error {
+	return a.change(func(accounts map[string]AcmeDnsAccount) {
+		delete(accounts, username)
+	})
+}
+
+// change saves the accounts as changed by apply, and only then uses them.
+func (a *AcmeDnsAccounts) change(apply func(accounts map[string]AcmeDnsAccount)) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	changed := make(map[string]AcmeDnsAccount, len(a.accounts)+1)
+	for username, existing := range a.accounts {
+		changed[username] = existing
+	}
+	apply(changed)
+
+	accounts := make([]AcmeDnsAccount, 0, len(changed))
+	for _, account := range changed {
+		accounts = append(accounts, account)
+	}
+
+	file, err := atomicfile.Open(a.filename)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(accounts); err != nil {
+		_ = file.Abort()
+		return err
+	}
+
+	if err = file.Commit(); err != nil {
+		return err
+	}
+
+	a.accounts = changed
+	return nil
+}
+
+// AllowedFrom checks whether the account may be used from ip.
+func (account AcmeDnsAccount) AllowedFrom(ip net.IP) bool {
+	if len(account.AllowFrom) == 0 {
+		return true
+	}
+
+	for _, cidr := range account.AllowFrom {
+		_, network, err := net.ParseCIDR(cidr)
+		if err == nil && ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (api *RestApi) acmeDnsRegister(w http.ResponseWriter, r *http.Request) {
+	request := acmeDnsRegisterRequest{}
+
+	// The body is optional
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "malformed_json_payload"})
+			return
+		}
+	}
+
+	allowFrom := make([]string, 0, len(request.AllowFrom))
+	for _, cidr := range request.AllowFrom {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_allowfrom_cidr"})
+			return
+		}
+		allowFrom = append(allowFrom, network.String())
+	}
+
+	account, password, err := newAcmeDnsAccount(allowFrom)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	zone, err := api.registerAcmeDnsAccount(r, account)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, acmeDnsRegisterResponse{
+		Username:   account.Username,
+		Password:   password,
+		FullDomain: strings.TrimSuffix(fmt.Sprint(account.Subdomain, ".", zone.Name), "."),
+		Subdomain:  account.Subdomain,
+		AllowFrom:  allowFrom,
+	})
+}
+
+// newAcmeDnsAccount generates a new account and returns it with its password.
+func newAcmeDnsAccount(allowFrom []string) (AcmeDnsAccount, string, error) {
+	username, err := newUUID()
+	if err != nil {
+		return AcmeDnsAccount{}, "", err
+	}
+
+	subdomain, err := newUUID()
+	if err != nil {
+		return AcmeDnsAccount{}, "", err
+	}
+
+	// 30 random bytes gives the 40 character passwords acme-dns clients expect
+	password, err := randomString(30)
+	if err != nil {
+		return AcmeDnsAccount{}, "", err
+	}
+
+	account := AcmeDnsAccount{
+		Username:  username,
+		KeyHash:   hashAcmeDnsKey(password),
+		Subdomain: subdomain,
+		AllowFrom: allowFrom,
+	}
+
+	return account, password, nil
+}
+
+// registerAcmeDnsAccount adds placeholder records for the account's subdomain
+// to the zone, and then saves the account.
+func (api *RestApi) registerAcmeDnsAccount(r *http.Request, account AcmeDnsAccount) (updater.Zone, error) {
+	placeholders := make([]updater.Record, acmeDnsValuesKept)
+	for i := range placeholders {
+		placeholders[i] = updater.Placeholder(account.Subdomain, "TXT")
+	}
+
+	// The account is saved first, as it is easier to remove again than the
+	// placeholders, which could have been updated by then
+	err := api.acmeDns.Add(account)
+	if err != nil {
+		return updater.Zone{}, fmt.Errorf("while saving acme-dns account: %s", err)
+	}
+
+	_, err = api.updater.AppendRecords(r.Context(), placeholders)
+	if err != nil {
+		if removeErr := api.acmeDns.Remove(account.Username); removeErr != nil {
+			logging.FromContext(r.Context()).Error("Unable to remove acme-dns account", "username", account.Username,
+				"error", removeErr)
+		}
+		return updater.Zone{}, err
+	}
+
+	return api.updater.Zone(r.Context())
+}
+
+func (api *RestApi) acmeDnsUpdate(w http.ResponseWriter, r *http.Request) {
+	account, ok := api.acmeDns.Check(r.Header.Get("X-Api-User"), r.Header.Get("X-Api-Key"))
+	if !ok || !account.AllowedFrom(clientIP(r)) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "forbidden"})
+		return
+	}
+
+	request := acmeDnsUpdateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "malformed_json_payload"})
+		return
+	}
+
+	if request.Subdomain != account.Subdomain {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "forbidden"})
+		return
+	}
+
+	if len(request.Txt) != acmeDnsTxtLength {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "bad_txt"})
+		return
+	}
+
+	_, err := api.updater.Update(r.Context(), updater.UpdateRequest{
+		FQDN:   account.Subdomain,
+		RRType: "TXT",
+		Value:  request.Txt,
+		Keep:   acmeDnsValuesKept,
+	})
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"txt": request.Txt})
+}
+
+func acmeDnsHealth(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func hashAcmeDnsKey(key string) string {
+	// Keys are long and random, so a fast hash is good enough
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	// version 4, variant 1
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func randomString(numBytes int) (string, error) {
+	b := make([]byte, numBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// clientIP is the IP address of the client, which will come from proxy headers
+// if --trust-proxy is in effect.
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
@@ -0,0 +1,85 @@
+package restapi_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"zoneupdated/config"
+)
+
+type acmeDnsAccount struct {
+	Username   string   `json:"username"`
+	Password   string   `json:"password"`
+	FullDomain string   `json:"fulldomain"`
+	Subdomain  string   `json:"subdomain"`
+	AllowFrom  []string `json:"allowfrom"`
+}
+
+func TestAcmeDns(t *testing.T) {
+	accountsFile := fmt.Sprintf("%s%c%d.acme-dns.json", os.TempDir(), os.PathSeparator, os.Getpid())
+	defer os.Remove(accountsFile)
+
+	handler, zoneFile := newTestApi(t, config.Config{AcmeDnsFile: accountsFile, ZoneName: "dyn.example.com"})
+	defer removeZoneFile(zoneFile)
+
+	var account acmeDnsAccount
+	response := request(handler, "POST", "/zone-update/acme-dns/register", `{"allowfrom": ["192.0.2.0/24"]}`)
+	checkStatus(t, response, http.StatusCreated)
+	decodeResponse(t, response, &account)
+
+	if account.FullDomain != account.Subdomain+".dyn.example.com" || len(account.Password) != 40 {
+		t.Errorf("Unexpected account %+v", account)
+	}
+
+	values := []string{strings.Repeat("a", 43), strings.Repeat("b", 43), strings.Repeat("c", 43)}
+	for _, value := range values {
+		response = request(handler, "POST", "/zone-update/acme-dns/update",
+			fmt.Sprintf(`{"subdomain": "%s", "txt": "%s"}`, account.Subdomain, value),
+			"X-Api-User", account.Username, "X-Api-Key", account.Password)
+		checkResponse(t, response, http.StatusOK, fmt.Sprintf("{\"txt\":\"%s\"}\n", value))
+	}
+
+	// The two most recent values are kept, newest first
+	contents, _ := ioutil.ReadFile(zoneFile)
+	expected := fmt.Sprintf("%s\tIN TXT\t%s\n%s\tIN TXT\t%s\n", account.Subdomain, values[2], account.Subdomain, values[1])
+	if !strings.HasSuffix(string(contents), expected) {
+		t.Errorf("Expected zone file to end with:\n%s\nbut it was:\n%s", expected, contents)
+	}
+
+	response = request(handler, "POST", "/zone-update/acme-dns/update",
+		fmt.Sprintf(`{"subdomain": "%s", "txt": "%s"}`, account.Subdomain, values[0]),
+		"X-Api-User", account.Username, "X-Api-Key", "wrong")
+	checkStatus(t, response, http.StatusUnauthorized)
+
+	response = request(handler, "POST", "/zone-update/acme-dns/update",
+		fmt.Sprintf(`{"subdomain": "%s", "txt": "%s"}`, "test", values[0]),
+		"X-Api-User", account.Username, "X-Api-Key", account.Password)
+	checkStatus(t, response, http.StatusUnauthorized)
+
+	response = request(handler, "POST", "/zone-update/acme-dns/update",
+		fmt.Sprintf(`{"subdomain": "%s", "txt": "%s"}`, account.Subdomain, "short"),
+		"X-Api-User", account.Username, "X-Api-Key", account.Password)
+	checkStatus(t, response, http.StatusBadRequest)
+}
+
+func TestAcmeDns_SaveFailed(t *testing.T) {
+	accountsFile := fmt.Sprintf("%s%c%d-missing%cacme-dns.json", os.TempDir(), os.PathSeparator, os.Getpid(),
+		os.PathSeparator)
+
+	handler, zoneFile := newTestApi(t, config.Config{AcmeDnsFile: accountsFile, ZoneName: "dyn.example.com"})
+	defer removeZoneFile(zoneFile)
+
+	before, _ := ioutil.ReadFile(zoneFile)
+
+	response := request(handler, "POST", "/zone-update/acme-dns/register", "")
+	checkStatus(t, response, http.StatusInternalServerError)
+
+	// No placeholders are left behind for an account that doesn't exist
+	after, _ := ioutil.ReadFile(zoneFile)
+	if string(after) != string(before) {
+		t.Errorf("Expected zone file to be unchanged but it was:\n%s", after)
+	}
+}
@@ -0,0 +1,81 @@
+package restapi
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"zoneupdated/authz"
+	"zoneupdated/httperror"
+	"zoneupdated/logging"
+)
+
+// parseNetworks parses a comma separated list of CIDRs.
+func parseNetworks(cidrs string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(cidrs, ",") {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func forbiddenAddress(ip net.IP) error {
+	return httperror.WithCode(http.StatusForbidden, httperror.ForbiddenAddress, fmt.Errorf("not allowed from %s", ip))
+}
+
+// checkAllowFrom refuses requests from clients outside the --http-allow-from
+// networks. The client's address is the one given by the proxy with
+// --trust-proxy.
+func (api *RestApi) checkAllowFrom(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if !contains(api.allowFrom, ip) {
+			logging.FromContext(r.Context()).Warn("Refused request from an address not in --http-allow-from", "address", ip)
+			writeError(w, r, forbiddenAddress(ip))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkUserFrom refuses requests by users from outside the networks their
+// policy allows.
+func checkUserFrom(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := userAllowedFrom(r); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// userAllowedFrom checks that the user of an authenticated request may
+// connect from the client's address.
+func userAllowedFrom(r *http.Request) error {
+	principal, _ := authz.FromContext(r.Context())
+	ip := clientIP(r)
+	if !principal.Policy.AllowedFrom(ip) {
+		logging.FromContext(r.Context()).Warn("Refused request from an address not in the user's policy", "address", ip)
+		return forbiddenAddress(ip)
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package restapi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"zoneupdated/authz"
+	"zoneupdated/httperror"
+	"zoneupdated/logging"
+)
+
+// loadClientCAs reads the CA certificates that client certificates are
+// verified against.
+func (api *RestApi) loadClientCAs() error {
+	pem, err := ioutil.ReadFile(api.conf.TlsClientCA)
+	if err != nil {
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in %s", api.conf.TlsClientCA)
+	}
+
+	api.clientCAs.Store(pool)
+	loaded("client_ca")
+
+	return nil
+}
+
+// getConfigForClient returns the TLS configuration for each connection, so
+// that the client CAs can be reloaded without restarting the server.
+func (api *RestApi) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	clientAuth := tls.RequireAndVerifyClientCert
+	if strings.EqualFold(api.conf.TlsClientAuth, "optional") {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	pool, _ := api.clientCAs.Load().(*x509.CertPool)
+	if pool == nil {
+		return nil, errors.New("no client CA certificates loaded")
+	}
+
+	return &tls.Config{
+		GetCertificate: api.getCertificate,
+		ClientAuth:     clientAuth,
+		ClientCAs:      pool,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}, nil
+}
+
+// certAuth authenticates requests by their verified client certificate.
+// Requests without one are passed to fallback, if there is one, and are
+// otherwise refused.
+func (api *RestApi) certAuth(fallback func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		var unauthenticated http.Handler
+		if fallback != nil {
+			unauthenticated = fallback(next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+				if unauthenticated != nil {
+					unauthenticated.ServeHTTP(w, r)
+				} else {
+					writeError(w, r, httperror.Error(http.StatusUnauthorized, errors.New("Unauthorized")))
+				}
+				return
+			}
+
+			principal, err := api.certPrincipal(r.TLS.VerifiedChains[0][0])
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("Client certificate refused", "error", err)
+				writeError(w, r, httperror.Error(http.StatusUnauthorized, errors.New("Unauthorized")))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(authz.NewContext(r.Context(), principal)))
+		})
+	}
+}
+
+// certPrincipal maps a client certificate to a user. With a password file,
+// the user must be in it, and has the policy given there.
+func (api *RestApi) certPrincipal(cert *x509.Certificate) (authz.Principal, error) {
+	identity := certIdentity(cert, api.conf.TlsClientIdentity)
+	if identity == "" {
+		return authz.Principal{}, fmt.Errorf("no %s in certificate %s", api.conf.TlsClientIdentity, cert.Subject)
+	}
+
+	if api.passwords == nil {
+		return authz.Principal{Name: identity}, nil
+	}
+
+	principal, ok := api.passwords.Principal(identity)
+	if !ok {
+		return authz.Principal{}, fmt.Errorf("unknown user %s", identity)
+	}
+
+	return principal, nil
+}
+
+// certIdentity returns the subject CN of cert, or with san its first DNS name,
+// email address or URI.
+func certIdentity(cert *x509.Certificate, source string) string {
+	if !strings.EqualFold(source, "san") {
+		return cert.Subject.CommonName
+	}
+
+	switch {
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	default:
+		return ""
+	}
+}
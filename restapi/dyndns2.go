@@ -0,0 +1,203 @@
+package restapi
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"zoneupdated/authz"
+	"zoneupdated/httperror"
+	"zoneupdated/logging"
+	"zoneupdated/updater"
+)
+
+// Return codes of the dyndns2 protocol
+const (
+	dynDns2Good    = "good"
+	dynDns2NoChg   = "nochg"
+	dynDns2NoHost  = "nohost"
+	dynDns2BadAuth = "badauth"
+	dynDns2Abuse   = "abuse"
+	dynDns2NotFqdn = "notfqdn"
+	dynDns2Error   = "911"
+)
+
+// dynDns2Update handles /nic/update requests from routers and clients like
+// ddclient and inadyn, updating A and AAAA records. It is authenticated in
+// the same way as the rest of the API, and refuses requests when no way of
+// authenticating users is configured, so that the addresses can't be
+// changed by anyone.
+func (api *RestApi) dynDns2Update(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authz.FromContext(r.Context()); !ok {
+		logging.FromContext(r.Context()).Warn("Refused dyndns2 request, as no authentication is configured")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	query := r.URL.Query()
+
+	var hostnames []string
+	for _, hostname := range strings.Split(query.Get("hostname"), ",") {
+		hostname = strings.TrimSpace(hostname)
+		if hostname != "" {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+
+	if len(hostnames) == 0 {
+		_, _ = fmt.Fprintln(w, dynDns2NotFqdn)
+		return
+	}
+
+	addresses, err := dynDns2Addresses(query.Get("myip"), query.Get("myipv6"))
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Bad dyndns2 request", "error", err)
+		_, _ = fmt.Fprintln(w, dynDns2Error)
+		return
+	}
+
+	if len(addresses) == 0 {
+		ip := clientIP(r)
+		if ip == nil {
+			_, _ = fmt.Fprintln(w, dynDns2Error)
+			return
+		}
+
+		addresses = append(addresses, ip)
+	}
+
+	for _, hostname := range hostnames {
+		_, _ = fmt.Fprintln(w, api.dynDns2UpdateHost(r, hostname, addresses))
+	}
+}
+
+// dynDns2UpdateHost updates the A and/or AAAA records for hostname and returns the
+// result code, with the addresses of the records that exist. Records the user
+// may not update are treated as not existing.
+func (api *RestApi) dynDns2UpdateHost(r *http.Request, hostname string, addresses []net.IP) string {
+	zone, err := api.updater.Zone(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("dyndns2 update failed", "hostname", hostname, "error", err)
+		return dynDns2Error
+	}
+
+	changed := false
+	found := false
+
+	var values []string
+	for _, ip := range addresses {
+		rrtype := "A"
+		if ip.To4() == nil {
+			rrtype = "AAAA"
+		}
+		result, err := api.dynDns2UpdateRecord(r, zone, hostname, rrtype, ip.String())
+		if errors.Is(err, updater.ErrNotFound) || httperror.StatusOf(err) == http.StatusForbidden {
+			continue
+		} else if err != nil {
+			logging.FromContext(r.Context()).Warn("dyndns2 update failed", "hostname", hostname, "error", err)
+			return dynDns2Error
+		}
+		found = true
+		values = append(values, ip.String())
+
+		changed = changed || result.Changed
+	}
+
+	if !found {
+		return dynDns2NoHost
+	}
+
+	code := dynDns2NoChg
+	if changed {
+		code = dynDns2Good
+	}
+
+	return fmt.Sprint(code, " ", strings.Join(values, ","))
+}
+
+// dynDns2UpdateRecord updates the record for hostname however it is written in
+// the zone file. Clients send host names without a trailing dot, but the zone
+// may have it written with one, or relative to the zone.
+func (api *RestApi) dynDns2UpdateRecord(r *http.Request, zone updater.Zone, hostname string, rrtype string, value string) (updater.Result, error) {
+	candidates := []string{hostname, hostname + "."}
+
+	suffix := "." + strings.TrimSuffix(zone.Name, ".")
+	if strings.HasSuffix(strings.ToLower(hostname), strings.ToLower(suffix)) {
+		candidates = append(candidates, hostname[:len(hostname)-len(suffix)])
+	}
+
+	name, err := api.updater.Resolve(r.Context(), candidates, rrtype)
+	if err != nil {
+		return updater.Result{}, err
+	}
+
+	return api.updater.Update(r.Context(), updater.UpdateRequest{FQDN: name, RRType: rrtype, Value: value})
+}
+
+// dynDns2Refusals writes the requests refused before reaching dynDns2Update,
+// such as by authentication or rate limits, with the dyndns2 return codes
+// that clients understand rather than the API's errors.
+func dynDns2Refusals(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&dynDns2Writer{ResponseWriter: w}, r)
+	})
+}
+
+// dynDns2Writer replaces the body of error responses with a return code.
+type dynDns2Writer struct {
+	http.ResponseWriter
+	refused bool
+}
+
+func (w *dynDns2Writer) WriteHeader(status int) {
+	if status < http.StatusBadRequest {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	code := dynDns2Error
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		code = dynDns2BadAuth
+	case http.StatusTooManyRequests:
+		code = dynDns2Abuse
+	}
+
+	w.refused = true
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.ResponseWriter.WriteHeader(status)
+	_, _ = fmt.Fprintln(w.ResponseWriter, code)
+}
+
+func (w *dynDns2Writer) Write(b []byte) (int, error) {
+	if w.refused {
+		return len(b), nil
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// dynDns2Addresses parses the myip and myipv6 parameters. myip may be a comma
+// separated list containing both an IPv4 and an IPv6 address.
+func dynDns2Addresses(myip string, myipv6 string) ([]net.IP, error) {
+	var addresses []net.IP
+
+	for _, value := range strings.Split(myip+","+myipv6, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address '%s'", value)
+		}
+
+		addresses = append(addresses, ip)
+	}
+
+	return addresses, nil
+}
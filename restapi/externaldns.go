@@ -0,0 +1,329 @@
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"net/http"
+	"strings"
+	"time"
+	"zoneupdated/httperror"
+	"zoneupdated/updater"
+)
+
+const (
+	externalDnsMediaType = "application/external.dns.webhook+json;version=1"
+	externalDnsHeritage  = "heritage=external-dns"
+)
+
+// Record types external-dns may manage. Only types whose values are a single
+// field are supported.
+var externalDnsTypes = map[string]bool{"A": true, "AAAA": true, "CNAME": true, "NS": true, "TXT": true}
+
+// externalDnsEndpoint is an external-dns Endpoint, a name and type with all of
+// its values.
+type externalDnsEndpoint struct {
+	DNSName          string            `json:"dnsName"`
+	Targets          []string          `json:"targets"`
+	RecordType       string            `json:"recordType"`
+	SetIdentifier    string            `json:"setIdentifier,omitempty"`
+	RecordTTL        int64             `json:"recordTTL,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	ProviderSpecific json.RawMessage   `json:"providerSpecific,omitempty"`
+}
+
+type externalDnsChanges struct {
+	Create    []externalDnsEndpoint `json:"Create"`
+	UpdateOld []externalDnsEndpoint `json:"UpdateOld"`
+	UpdateNew []externalDnsEndpoint `json:"UpdateNew"`
+	Delete    []externalDnsEndpoint `json:"Delete"`
+}
+
+type externalDnsDomainFilter struct {
+	Include []string `json:"include"`
+}
+
+// ExternalDnsHandler returns the handler for the external-dns webhook provider
+// API. It has no authentication, since external-dns expects to reach it on
+// localhost.
+func (api *RestApi) ExternalDnsHandler() http.Handler {
+	r := chi.NewRouter()
+
+	r.Use(middleware.RequestID)
+	r.Use(auditRequest)
+	r.Use(logRequests)
+	r.Use(middleware.Recoverer)
+	r.Use(middleware.Timeout(time.Second * time.Duration(api.conf.HttpTimeoutSecs)))
+
+	r.Get("/", api.externalDnsNegotiate)
+	r.Get("/records", api.externalDnsRecords)
+	r.Post("/records", api.externalDnsApplyChanges)
+	r.Post("/adjustendpoints", externalDnsAdjustEndpoints)
+
+	return r
+}
+
+// externalDnsNegotiate tells external-dns which domain zoneupdated manages.
+func (api *RestApi) externalDnsNegotiate(w http.ResponseWriter, r *http.Request) {
+	zone, err := api.updater.Zone(r.Context())
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	writeExternalDns(w, http.StatusOK, externalDnsDomainFilter{Include: []string{strings.TrimSuffix(zone.Name, ".")}})
+}
+
+func (api *RestApi) externalDnsRecords(w http.ResponseWriter, r *http.Request) {
+	zone, err := api.updater.Zone(r.Context())
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	writeExternalDns(w, http.StatusOK, externalDnsEndpoints(zone))
+}
+
+// externalDnsEndpoints groups the enabled records in the zone that external-dns
+// may manage into endpoints.
+func externalDnsEndpoints(zone updater.Zone) []externalDnsEndpoint {
+	endpoints := []externalDnsEndpoint{}
+	index := make(map[string]int)
+
+	for _, record := range zone.Records {
+		fqdn := zone.FQDN(record.Name)
+		if !record.Enabled || !externalDnsTypes[record.RRType] ||
+			record.RRType == "NS" && strings.EqualFold(fqdn, zone.Name) {
+			continue
+		}
+
+		target := record.Value
+		switch record.RRType {
+		case "CNAME", "NS":
+			target = strings.TrimSuffix(zone.FQDN(target), ".")
+		case "TXT":
+			target = quoteTxt(target)
+		}
+
+		key := strings.ToLower(fqdn) + " " + record.RRType
+		i, ok := index[key]
+		if !ok {
+			i = len(endpoints)
+			index[key] = i
+			endpoints = append(endpoints, externalDnsEndpoint{
+				DNSName:    strings.ToLower(strings.TrimSuffix(fqdn, ".")),
+				RecordType: record.RRType,
+				RecordTTL:  int64(record.TTL),
+			})
+		}
+
+		endpoints[i].Targets = append(endpoints[i].Targets, target)
+	}
+
+	return endpoints
+}
+
+// externalDnsApplyChanges makes all of the changes in an external-dns plan in
+// one update of the zone file.
+func (api *RestApi) externalDnsApplyChanges(w http.ResponseWriter, r *http.Request) {
+	var plan externalDnsChanges
+	if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+		writeJSONError(w, badRequest("JSON Parse error: %s", err))
+		return
+	}
+
+	var changes updater.Changes
+	var err error
+
+	// Updates are made by removing the old values and adding the new ones
+	changes.Delete, err = externalDnsRecordsFor(append(plan.Delete, plan.UpdateOld...))
+	if err == nil {
+		changes.Create, err = externalDnsRecordsFor(append(plan.Create, plan.UpdateNew...))
+	}
+	if err != nil {
+		writeJSONError(w, badRequest("%s", err))
+		return
+	}
+
+	_, err = api.updater.ApplyChanges(r.Context(), changes, func(zone updater.Zone) error {
+		return checkExternalDnsOwnership(zone, changes)
+	})
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// externalDnsRecordsFor converts endpoints into a record for each of their targets.
+func externalDnsRecordsFor(endpoints []externalDnsEndpoint) ([]updater.Record, error) {
+	var records []updater.Record
+
+	for _, endpoint := range endpoints {
+		rrtype := strings.ToUpper(endpoint.RecordType)
+		if !externalDnsTypes[rrtype] {
+			return nil, fmt.Errorf("unsupported record type %s for %s", endpoint.RecordType, endpoint.DNSName)
+		}
+
+		if endpoint.SetIdentifier != "" {
+			return nil, fmt.Errorf("set identifiers are not supported, for %s", endpoint.DNSName)
+		}
+
+		if endpoint.RecordTTL < 0 || endpoint.RecordTTL > 1<<31-1 {
+			return nil, fmt.Errorf("invalid TTL %d for %s", endpoint.RecordTTL, endpoint.DNSName)
+		}
+
+		for _, target := range endpoint.Targets {
+			switch rrtype {
+			case "CNAME", "NS":
+				target = strings.TrimSuffix(target, ".") + "."
+			case "TXT":
+				target = unquoteTxt(target)
+			}
+
+			records = append(records, updater.Record{
+				Name:   strings.TrimSuffix(endpoint.DNSName, ".") + ".",
+				TTL:    uint32(endpoint.RecordTTL),
+				RRType: rrtype,
+				Value:  target,
+			})
+		}
+	}
+
+	return records, nil
+}
+
+// checkExternalDnsOwnership makes sure that external-dns only changes names in
+// the zone that it owns. A name is owned if it has a TXT registry record with
+// external-dns as its heritage, either at the name itself or with the record
+// type as a prefix, as external-dns writes them. Only registry records already
+// in the zone let records be deleted. Names without any records may be created
+// along with their registry records in the same batch, but registry records
+// can't be created for names that have records external-dns doesn't own,
+// whether at the name itself or after a record type prefix.
+func checkExternalDnsOwnership(zone updater.Zone, changes updater.Changes) error {
+	keys := func(record updater.Record) []string {
+		fqdn := strings.ToLower(zone.FQDN(record.Name))
+		return []string{fqdn, strings.ToLower(record.RRType) + "-" + fqdn}
+	}
+
+	owned := make(map[string]bool)
+	for _, record := range zone.Records {
+		if record.Enabled && isExternalDnsRegistry(record) {
+			owned[strings.ToLower(zone.FQDN(record.Name))] = true
+		}
+	}
+	isOwned := func(record updater.Record) bool {
+		k := keys(record)
+		return owned[k[0]] || owned[k[1]]
+	}
+
+	// Names with records, and those with records external-dns doesn't own
+	hasRecords := make(map[string]bool)
+	unowned := make(map[string]bool)
+	for _, record := range zone.Records {
+		if record.Enabled && !isExternalDnsRegistry(record) {
+			hasRecords[strings.ToLower(zone.FQDN(record.Name))] = true
+			if !isOwned(record) {
+				unowned[strings.ToLower(zone.FQDN(record.Name))] = true
+			}
+		}
+	}
+
+	created := make(map[string]bool)
+	for _, record := range changes.Create {
+		if isExternalDnsRegistry(record) {
+			created[strings.ToLower(zone.FQDN(record.Name))] = true
+		}
+	}
+
+	for _, record := range append(changes.Delete, changes.Create...) {
+		// Names outside the zone are left fully qualified
+		if fqdn := zone.FQDN(record.Name); zone.RelativeName(fqdn) == fqdn {
+			return httperror.WithCode(http.StatusForbidden, httperror.ForbiddenName,
+				fmt.Errorf("%s is not in zone %s", record.Name, zone.Name))
+		}
+	}
+
+	for _, record := range changes.Delete {
+		if !isExternalDnsRegistry(record) && !isOwned(record) {
+			return externalDnsNotOwned(record)
+		}
+	}
+
+	for _, record := range changes.Create {
+		k := keys(record)
+		switch {
+		case isExternalDnsRegistry(record):
+			if unowned[k[0]] || unowned[externalDnsBaseName(k[0])] {
+				return httperror.WithCode(http.StatusForbidden, httperror.ForbiddenName,
+					fmt.Errorf("%s has records that are not owned by external-dns", record.Name))
+			}
+		case isOwned(record):
+		case (created[k[0]] || created[k[1]]) && !hasRecords[k[0]]:
+		default:
+			return externalDnsNotOwned(record)
+		}
+	}
+
+	return nil
+}
+
+// externalDnsBaseName returns fqdn without a record type prefix, such as the
+// aaaa- of a registry record for the AAAA records of a name, or fqdn if it has none.
+func externalDnsBaseName(fqdn string) string {
+	if i := strings.Index(fqdn, "-"); i > 0 && i < strings.Index(fqdn, ".") && externalDnsTypes[strings.ToUpper(fqdn[:i])] {
+		return fqdn[i+1:]
+	}
+
+	return fqdn
+}
+
+func externalDnsNotOwned(record updater.Record) error {
+	return httperror.WithCode(http.StatusForbidden, httperror.ForbiddenName,
+		fmt.Errorf("%s %s is not owned by external-dns", record.Name, record.RRType))
+}
+
+func isExternalDnsRegistry(record updater.Record) bool {
+	return strings.EqualFold(record.RRType, "TXT") && strings.Contains(record.Value, externalDnsHeritage)
+}
+
+// externalDnsAdjustEndpoints drops anything from the desired endpoints that
+// zoneupdated can't store, so that external-dns doesn't keep trying to change it.
+func externalDnsAdjustEndpoints(w http.ResponseWriter, r *http.Request) {
+	var endpoints []externalDnsEndpoint
+	if err := json.NewDecoder(r.Body).Decode(&endpoints); err != nil {
+		writeJSONError(w, badRequest("JSON Parse error: %s", err))
+		return
+	}
+
+	adjusted := make([]externalDnsEndpoint, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		endpoint.DNSName = strings.ToLower(strings.TrimSuffix(endpoint.DNSName, "."))
+		endpoint.ProviderSpecific = nil
+		adjusted = append(adjusted, endpoint)
+	}
+
+	writeExternalDns(w, http.StatusOK, adjusted)
+}
+
+func writeExternalDns(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", externalDnsMediaType)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// quoteTxt quotes a TXT value the way external-dns does.
+func quoteTxt(value string) string {
+	return fmt.Sprint("\"", strings.ReplaceAll(value, "\"", "\\\""), "\"")
+}
+
+func unquoteTxt(value string) string {
+	if len(value) < 2 || !strings.HasPrefix(value, "\"") || !strings.HasSuffix(value, "\"") {
+		return value
+	}
+
+	return strings.ReplaceAll(value[1:len(value)-1], "\\\"", "\"")
+}
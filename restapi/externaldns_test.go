@@ -0,0 +1,135 @@
+package restapi_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+	"zoneupdated/config"
+)
+
+const externalDnsMediaType = "application/external.dns.webhook+json;version=1"
+
+func TestExternalDns(t *testing.T) {
+	api, zoneFile := newTestRestApi(t, config.Config{}, "testdata/externaldns/test.zone")
+	defer removeZoneFile(zoneFile)
+	handler := api.ExternalDnsHandler()
+
+	response := request(handler, "GET", "/", "", "Accept", externalDnsMediaType)
+	checkResponse(t, response, http.StatusOK, `{"include":["dyn.example.com"]}`+"\n")
+	if response.Header().Get("Content-Type") != externalDnsMediaType {
+		t.Errorf("Unexpected content type %s", response.Header().Get("Content-Type"))
+	}
+
+	response = request(handler, "GET", "/records", "")
+	checkStatus(t, response, http.StatusOK)
+	checkJSONFixture(t, response.Body.Bytes(), "testdata/externaldns/records.json")
+
+	response = request(handler, "POST", "/records", readFixture(t, "testdata/externaldns/changes.json"))
+	checkStatus(t, response, http.StatusNoContent)
+
+	zone := readFixture(t, zoneFile)
+	if expected := readFixture(t, "testdata/externaldns/changed.zone"); zone != expected {
+		t.Errorf("Zone file after changes was\n%s\nexpected\n%s", zone, expected)
+	}
+}
+
+func TestExternalDns_Ownership(t *testing.T) {
+	api, zoneFile := newTestRestApi(t, config.Config{}, "testdata/externaldns/test.zone")
+	defer removeZoneFile(zoneFile)
+	handler := api.ExternalDnsHandler()
+
+	original := readFixture(t, zoneFile)
+
+	// static has no registry record, so it isn't owned by external-dns
+	response := request(handler, "POST", "/records",
+		`{"Delete": [{"dnsName": "static.dyn.example.com", "targets": ["192.0.2.1"], "recordType": "A"}]}`)
+	checkStatus(t, response, http.StatusForbidden)
+
+	// Neither is a new name without a registry record
+	response = request(handler, "POST", "/records",
+		`{"Create": [{"dnsName": "new.dyn.example.com", "targets": ["192.0.2.5"], "recordType": "A"}]}`)
+	checkStatus(t, response, http.StatusForbidden)
+
+	// A batch is refused as a whole
+	response = request(handler, "POST", "/records",
+		`{"UpdateOld": [{"dnsName": "app.dyn.example.com", "targets": ["192.0.2.20"], "recordType": "A"}],
+		  "UpdateNew": [{"dnsName": "app.dyn.example.com", "targets": ["192.0.2.21"], "recordType": "A"}],
+		  "Create": [{"dnsName": "other.example.org", "targets": ["192.0.2.5"], "recordType": "A"}]}`)
+	checkStatus(t, response, http.StatusForbidden)
+
+	// Creating a registry record doesn't take over records in the same batch
+	response = request(handler, "POST", "/records",
+		`{"Create": [{"dnsName": "a-static.dyn.example.com", "targets": ["\"heritage=external-dns,external-dns/owner=default\""], "recordType": "TXT"}],
+		  "Delete": [{"dnsName": "static.dyn.example.com", "targets": ["192.0.2.1"], "recordType": "A"}]}`)
+	checkStatus(t, response, http.StatusForbidden)
+
+	// Or in a later one
+	response = request(handler, "POST", "/records",
+		`{"Create": [{"dnsName": "a-static.dyn.example.com", "targets": ["\"heritage=external-dns,external-dns/owner=default\""], "recordType": "TXT"}]}`)
+	checkStatus(t, response, http.StatusForbidden)
+	response = request(handler, "POST", "/records",
+		`{"Create": [{"dnsName": "static.dyn.example.com", "targets": ["\"heritage=external-dns,external-dns/owner=default\""], "recordType": "TXT"},
+		             {"dnsName": "static.dyn.example.com", "targets": ["192.0.2.2"], "recordType": "A"}]}`)
+	checkStatus(t, response, http.StatusForbidden)
+
+	// Nor by a registry record for another type of records at the name
+	response = request(handler, "POST", "/records",
+		`{"Create": [{"dnsName": "aaaa-static.dyn.example.com", "targets": ["\"heritage=external-dns,external-dns/owner=default\""], "recordType": "TXT"}]}`)
+	checkStatus(t, response, http.StatusForbidden)
+	response = request(handler, "POST", "/records",
+		`{"Create": [{"dnsName": "static.dyn.example.com", "targets": ["2001:db8::5"], "recordType": "AAAA"}]}`)
+	checkStatus(t, response, http.StatusForbidden)
+
+	response = request(handler, "POST", "/records",
+		`{"Delete": [{"dnsName": "app.dyn.example.com", "targets": ["192.0.2.99"], "recordType": "A"}]}`)
+	checkStatus(t, response, http.StatusNotFound)
+
+	response = request(handler, "POST", "/records",
+		`{"Create": [{"dnsName": "app.dyn.example.com", "targets": ["10 mx.example.com"], "recordType": "MX"}]}`)
+	checkStatus(t, response, http.StatusBadRequest)
+
+	if zone := readFixture(t, zoneFile); zone != original {
+		t.Errorf("Zone file was changed by refused changes:\n%s", zone)
+	}
+}
+
+func TestExternalDns_AdjustEndpoints(t *testing.T) {
+	api, zoneFile := newTestRestApi(t, config.Config{}, "testdata/externaldns/test.zone")
+	defer removeZoneFile(zoneFile)
+
+	response := request(api.ExternalDnsHandler(), "POST", "/adjustendpoints",
+		`[{"dnsName": "App.dyn.example.com.", "targets": ["192.0.2.1"], "recordType": "A",
+		   "providerSpecific": [{"name": "alias", "value": "true"}]}]`)
+	checkResponse(t, response, http.StatusOK,
+		`[{"dnsName":"app.dyn.example.com","targets":["192.0.2.1"],"recordType":"A"}]`+"\n")
+}
+
+func readFixture(t *testing.T, filename string) string {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Unable to read %s: %s", filename, err)
+	}
+
+	return string(content)
+}
+
+// checkJSONFixture compares JSON ignoring formatting.
+func checkJSONFixture(t *testing.T, actual []byte, filename string) {
+	t.Helper()
+
+	var actualValue, expectedValue interface{}
+	if err := json.Unmarshal(actual, &actualValue); err != nil {
+		t.Fatalf("Unable to decode response: %s", err)
+	}
+	if err := json.Unmarshal([]byte(readFixture(t, filename)), &expectedValue); err != nil {
+		t.Fatalf("Unable to decode %s: %s", filename, err)
+	}
+
+	if !reflect.DeepEqual(actualValue, expectedValue) {
+		t.Errorf("Response %s does not match %s", actual, filename)
+	}
+}
@@ -0,0 +1,76 @@
+package restapi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"zoneupdated/logging"
+)
+
+// Certificates expiring sooner than this make the daemon unready, so that it
+// is noticed before clients start refusing to connect.
+const certExpiryMargin = 24 * time.Hour
+
+type readyResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// healthz responds while the daemon is running.
+func healthz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, readyResponse{Status: "ok", Checks: map[string]string{}})
+}
+
+// readyz checks that the daemon can serve requests: that the zone file can
+// be read and replaced, and that its certificate and auth file are loaded.
+func (api *RestApi) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := api.updater.Ready(r.Context())
+	if api.conf.UseHttps() {
+		checks["tls_cert"] = api.checkCert(time.Now())
+	}
+	if api.conf.HttpAuthFile != "" && api.passwords == nil {
+		checks["auth_file"] = errors.New("auth file not loaded")
+	} else if api.conf.HttpAuthFile != "" {
+		checks["auth_file"] = nil
+	}
+
+	// Errors name files and paths, so they are only logged
+	response := readyResponse{Status: "ok", Checks: make(map[string]string, len(checks))}
+	status := http.StatusOK
+	for name, err := range checks {
+		response.Checks[name] = "ok"
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("Not ready", "check", name, "error", err)
+			response.Checks[name] = "error"
+			response.Status = "error"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(w, status, response)
+}
+
+// checkCert checks that the certificate is loaded and not near expiry.
+func (api *RestApi) checkCert(now time.Time) error {
+	cert, ok := api.cert.Load().(*tls.Certificate)
+	if !ok || cert == nil || len(cert.Certificate) == 0 {
+		return errors.New("no certificate loaded")
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+
+	if now.Add(certExpiryMargin).After(leaf.NotAfter) {
+		return fmt.Errorf("certificate expires at %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
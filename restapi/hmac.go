@@ -0,0 +1,88 @@
+package restapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+	"zoneupdated/authz"
+	"zoneupdated/hmacauth"
+	"zoneupdated/httperror"
+	"zoneupdated/logging"
+)
+
+// The largest body of a signed request, which has to be read to check it
+const maxSignedBody = 1 << 20
+
+// hmacAuth authenticates requests signed with a shared secret. Requests that
+// aren't signed are passed to fallback, if there is one, and are otherwise
+// refused.
+func (api *RestApi) hmacAuth(fallback func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		var unauthenticated http.Handler
+		if fallback != nil {
+			unauthenticated = fallback(next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if !strings.HasPrefix(strings.ToUpper(header), hmacauth.Scheme+" ") {
+				if unauthenticated != nil {
+					unauthenticated.ServeHTTP(w, r)
+				} else {
+					w.Header().Set("WWW-Authenticate", hmacauth.Scheme+` realm="`+api.conf.HttpAuthRealm+`"`)
+					writeError(w, r, httperror.Error(http.StatusUnauthorized, errors.New("Unauthorized")))
+				}
+				return
+			}
+
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+			if err != nil {
+				writeError(w, r, badRequest("unable to read body: %s", err))
+				return
+			} else if len(body) > maxSignedBody {
+				writeError(w, r, httperror.Error(http.StatusRequestEntityTooLarge, errors.New("body too large to sign")))
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			principal, err := api.hmacPrincipal(header, r.Method, r.URL.RequestURI(), body)
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("Signed request refused", "error", err)
+				w.Header().Set("WWW-Authenticate", hmacauth.Scheme+` realm="`+api.conf.HttpAuthRealm+`"`)
+				writeError(w, r, httperror.Error(http.StatusUnauthorized, errors.New("Unauthorized")))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(authz.NewContext(r.Context(), principal)))
+		})
+	}
+}
+
+// hmacPrincipal checks a signed request, and returns the principal for its key.
+func (api *RestApi) hmacPrincipal(header string, method string, uri string, body []byte) (authz.Principal, error) {
+	signature, err := hmacauth.Parse(header)
+	if err != nil {
+		return authz.Principal{}, err
+	}
+
+	key, ok := api.hmacKeys.Lookup(signature.KeyID)
+	if !ok {
+		return authz.Principal{}, fmt.Errorf("unknown key %s", signature.KeyID)
+	}
+
+	if !signature.Verify(key.Secret, method, uri, body) {
+		return authz.Principal{}, fmt.Errorf("invalid signature by key %s", key.ID)
+	}
+
+	// Only signatures that verify use up nonces, so they can't be used up by others
+	if err = api.replays.Check(signature, time.Now()); err != nil {
+		return authz.Principal{}, fmt.Errorf("key %s: %s", key.ID, err)
+	}
+
+	return authz.Principal{Name: "hmac:" + key.ID, Policy: key.Policy}, nil
+}
@@ -0,0 +1,129 @@
+package restapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+	"zoneupdated/authz"
+	"zoneupdated/httperror"
+	"zoneupdated/idempotency"
+	"zoneupdated/logging"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// idempotencyRecorder passes a response through while keeping a copy of it.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (recorder *idempotencyRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *idempotencyRecorder) Write(p []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	recorder.body.Write(p)
+	return recorder.ResponseWriter.Write(p)
+}
+
+// idempotent makes requests with an Idempotency-Key header safe to retry. The
+// response to the first request with a key is remembered, and a repeat of the
+// request gets the same response without it being made again.
+func (api *RestApi) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || api.idempotency == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, r, badRequest("Idempotency-Key is too long"))
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, r, badRequest("Unable to read request: %s", err))
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		// Keys are per user, however they authenticated
+		principal, _ := authz.FromContext(r.Context())
+		entry, err := api.idempotency.Lock(r.Context(), principal.Name, key)
+		if err != nil {
+			writeError(w, r, httperror.WithCode(http.StatusConflict, httperror.LockTimeout, err))
+			return
+		}
+		defer entry.Unlock()
+
+		fingerprint := requestFingerprint(r, body)
+
+		response, found, err := entry.Load()
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Unable to load response for idempotency key", "error", err)
+			writeError(w, r, err)
+			return
+		}
+
+		if found {
+			if response.Fingerprint != fingerprint {
+				writeError(w, r, httperror.WithCode(http.StatusUnprocessableEntity, httperror.IdempotencyKeyReused,
+					errors.New("Idempotency-Key was already used for a different request")))
+				return
+			}
+
+			if response.ContentType != "" {
+				w.Header().Set("Content-Type", response.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(response.Status)
+			_, _ = w.Write(response.Body)
+			return
+		}
+
+		recorder := &idempotencyRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		// Nothing was changed after lock timeouts and conflicts, so those
+		// requests may be tried again. Server errors are remembered, as the
+		// zone file may have been written before one
+		if recorder.status == http.StatusConflict {
+			return
+		}
+
+		err = entry.Save(idempotency.Response{
+			Fingerprint: fingerprint,
+			Created:     time.Now(),
+			Status:      recorder.status,
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		})
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Unable to save response for idempotency key", "error", err)
+		}
+	})
+}
+
+// requestFingerprint identifies a request, so that a key reused for a
+// different request can be detected. Accept is included, as it decides the
+// format of the response that is replayed.
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	_, _ = hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	_, _ = hash.Write([]byte(r.Header.Get("Accept") + "\n"))
+	_, _ = hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
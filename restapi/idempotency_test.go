@@ -0,0 +1,97 @@
+package restapi_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"zoneupdated/config"
+	"zoneupdated/updater"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	handler, zoneFile := newTestApi(t, config.Config{IdempotencyWindow: 60})
+	defer removeZoneFile(zoneFile)
+
+	present := func(value string, key string) *http.Response {
+		response := request(handler, "POST", "/zone-update/present",
+			`{"fqdn": "test", "rrtype": "A", "value": "`+value+`"}`, "Idempotency-Key", key, "Accept", "application/json")
+		return response.Result()
+	}
+
+	var first, replayed updater.Result
+	response := request(handler, "POST", "/zone-update/present", `{"fqdn": "test", "rrtype": "A", "value": "192.0.2.2"}`,
+		"Idempotency-Key", "key-1", "Accept", "application/json")
+	checkStatus(t, response, http.StatusOK)
+	decodeResponse(t, response, &first)
+
+	// A newer change, which the retry must not undo
+	if result := present("192.0.2.3", "key-2"); result.StatusCode != http.StatusOK {
+		t.Fatalf("Second update failed with status %d", result.StatusCode)
+	}
+
+	response = request(handler, "POST", "/zone-update/present", `{"fqdn": "test", "rrtype": "A", "value": "192.0.2.2"}`,
+		"Idempotency-Key", "key-1", "Accept", "application/json")
+	checkStatus(t, response, http.StatusOK)
+	decodeResponse(t, response, &replayed)
+	if response.Header().Get("Idempotent-Replayed") != "true" || replayed.Serial != first.Serial || !replayed.Changed {
+		t.Errorf("Expected the original result %+v to be replayed but got %+v", first, replayed)
+	}
+
+	response = request(handler, "GET", "/zone-update/records/test/A", "")
+	var zone updater.Zone
+	decodeResponse(t, response, &zone)
+	if len(zone.Records) != 1 || zone.Records[0].Value != "192.0.2.3" || zone.Serial != first.Serial+1 {
+		t.Errorf("Retry changed the zone: %+v", zone)
+	}
+
+	if result := present("192.0.2.4", "key-1"); result.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected reusing a key for a different request to fail but got status %d", result.StatusCode)
+	}
+
+	// The response would be replayed in the format of the first
+	response = request(handler, "POST", "/zone-update/present", `{"fqdn": "test", "rrtype": "A", "value": "192.0.2.2"}`,
+		"Idempotency-Key", "key-1", "Accept", "text/plain")
+	checkStatus(t, response, http.StatusUnprocessableEntity)
+
+	// Errors other than lock timeouts are remembered too
+	response = request(handler, "POST", "/zone-update/present", `{"fqdn": "nosuchname", "value": "x"}`,
+		"Idempotency-Key", "key-3")
+	checkStatus(t, response, http.StatusBadRequest)
+	response = request(handler, "POST", "/zone-update/present", `{"fqdn": "nosuchname", "value": "x"}`,
+		"Idempotency-Key", "key-3")
+	checkStatus(t, response, http.StatusBadRequest)
+	if response.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("Expected error response to be replayed")
+	}
+}
+
+func TestIdempotencyKey_DirUnwritable(t *testing.T) {
+	api, zoneFile := newTestRestApi(t, config.Config{IdempotencyWindow: 60}, "testdata/test.zone")
+	defer removeZoneFile(zoneFile)
+
+	// A file where the default directory would be, as with a bind mounted zone file
+	if err := ioutil.WriteFile(zoneFile+".idempotency", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	handler, err := api.Handler()
+	if err != nil {
+		t.Fatalf("Expected idempotency keys to be disabled but got: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		response := request(handler, "POST", "/zone-update/present", `{"fqdn": "test", "rrtype": "A", "value": "192.0.2.2"}`,
+			"Idempotency-Key", "key-1")
+		checkStatus(t, response, http.StatusOK)
+		if response.Header().Get("Idempotent-Replayed") != "" {
+			t.Error("Expected the response not to be replayed")
+		}
+	}
+
+	// A directory that was asked for has to work
+	api, _ = newTestRestApi(t, config.Config{IdempotencyWindow: 60, IdempotencyDir: zoneFile + ".idempotency"},
+		"testdata/test.zone")
+	if _, err = api.Handler(); err == nil {
+		t.Error("Expected an unwritable --idempotency-dir to be an error")
+	}
+}
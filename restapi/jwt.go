@@ -0,0 +1,116 @@
+package restapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"zoneupdated/authz"
+	"zoneupdated/httperror"
+	"zoneupdated/jwt"
+	"zoneupdated/logging"
+)
+
+// jwtAuth authenticates requests with a JWT in an Authorization: Bearer
+// header. Requests without one, including those with API tokens, are passed
+// to fallback, if there is one, and are otherwise refused.
+func (api *RestApi) jwtAuth(fallback func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		var unauthenticated http.Handler
+		if fallback != nil {
+			unauthenticated = fallback(next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") || strings.Count(header, ".") != 2 {
+				if unauthenticated != nil {
+					unauthenticated.ServeHTTP(w, r)
+				} else {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="`+api.conf.HttpAuthRealm+`"`)
+					writeError(w, r, httperror.Error(http.StatusUnauthorized, errors.New("Unauthorized")))
+				}
+				return
+			}
+
+			principal, err := api.jwtPrincipal(strings.TrimSpace(header[7:]))
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("JWT refused", "error", err)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+api.conf.HttpAuthRealm+`", error="invalid_token"`)
+				writeError(w, r, httperror.Error(http.StatusUnauthorized, errors.New("Unauthorized")))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(authz.NewContext(r.Context(), principal)))
+		})
+	}
+}
+
+// jwtPrincipal verifies token and maps its claims to a user. With a names
+// claim, the policy comes from the claims, and otherwise the user must be in
+// the password file, and has the policy given there.
+func (api *RestApi) jwtPrincipal(token string) (authz.Principal, error) {
+	claims, err := api.jwt.Verify(token)
+	if err != nil {
+		return authz.Principal{}, err
+	}
+
+	user, _ := claims[api.conf.JwtUserClaim].(string)
+	if user == "" {
+		return authz.Principal{}, fmt.Errorf("JWT has no %s", api.conf.JwtUserClaim)
+	}
+
+	if api.conf.JwtNamesClaim != "" {
+		policy, err := claimsPolicy(claims, api.conf.JwtNamesClaim, api.conf.JwtTypesClaim, api.conf.JwtOpsClaim)
+		if err != nil {
+			return authz.Principal{}, fmt.Errorf("JWT for %s: %s", user, err)
+		}
+
+		return authz.Principal{Name: user, Policy: policy}, nil
+	}
+
+	if api.passwords == nil {
+		return authz.Principal{}, fmt.Errorf("no policy for %s", user)
+	}
+
+	principal, ok := api.passwords.Principal(user)
+	if !ok {
+		return authz.Principal{}, fmt.Errorf("unknown user %s", user)
+	}
+
+	return principal, nil
+}
+
+// claimsPolicy makes a policy from the names, types and operations listed in
+// claims. Claims can't grant the admin operation.
+func claimsPolicy(claims jwt.Claims, namesClaim string, typesClaim string, opsClaim string) (*authz.Policy, error) {
+	names := claims.Strings(namesClaim)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no %s", namesClaim)
+	}
+
+	fields := []string{"allow=" + strings.Join(names, ",")}
+
+	if typesClaim != "" {
+		types := claims.Strings(typesClaim)
+		if len(types) == 0 {
+			return nil, fmt.Errorf("no %s", typesClaim)
+		}
+		fields = append(fields, "types="+strings.Join(types, ","))
+	}
+
+	if opsClaim != "" {
+		var ops []string
+		for _, op := range claims.Strings(opsClaim) {
+			if authz.Operation(strings.ToLower(op)) != authz.Admin {
+				ops = append(ops, op)
+			}
+		}
+		if len(ops) == 0 {
+			return nil, fmt.Errorf("no %s", opsClaim)
+		}
+		fields = append(fields, "ops="+strings.Join(ops, ","))
+	}
+
+	return authz.ParsePolicy(fields)
+}
@@ -0,0 +1,74 @@
+package restapi
+
+import (
+	"context"
+	"github.com/go-chi/chi/middleware"
+	"net/http"
+	"time"
+	"zoneupdated/authz"
+	"zoneupdated/logging"
+)
+
+type requestLogKey struct{}
+
+// requestLog is what is learnt about a request while handling it, for the
+// message logged when it completes.
+type requestLog struct {
+	principal string
+}
+
+// logRequests gives each request a logger with its ID and client address, and
+// logs each request when it completes, instead of chi's Logger.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		logger := logging.Default().With("request_id", middleware.GetReqID(r.Context()), "client_ip", clientIP(r).String())
+		entry := &requestLog{}
+
+		ctx := logging.NewContext(r.Context(), logger)
+		ctx = context.WithValue(ctx, requestLogKey{}, entry)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		if entry.principal != "" {
+			logger = logger.With("principal", entry.principal)
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		observeRequest(r, status, time.Since(started))
+
+		log := logger.Info
+		if status >= http.StatusInternalServerError {
+			log = logger.Error
+		}
+		log("Request", "method", r.Method, "path", r.URL.Path, "proto", r.Proto, "status", status,
+			"bytes", ww.BytesWritten(), "duration_ms", float64(time.Since(started).Microseconds())/1000)
+	})
+}
+
+// logPrincipal adds the authenticated user to the request's logger.
+func logPrincipal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, withPrincipalLogger(r))
+	})
+}
+
+// withPrincipalLogger returns r with the authenticated user, if there is one,
+// added to its logger.
+func withPrincipalLogger(r *http.Request) *http.Request {
+	principal, ok := authz.FromContext(r.Context())
+	if !ok {
+		return r
+	}
+
+	if entry, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		entry.principal = principal.Name
+	}
+
+	logger := logging.FromContext(r.Context()).With("principal", principal.Name)
+	return r.WithContext(logging.NewContext(r.Context(), logger))
+}
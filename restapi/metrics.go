@@ -0,0 +1,124 @@
+package restapi
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+	"zoneupdated/ratelimit"
+)
+
+var (
+	// Requests aren't counted by principal, as every token and JWT subject
+	// would be a new series
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoneupdated_http_requests_total",
+		Help: "HTTP requests, by endpoint and status.",
+	}, []string{"endpoint", "status"})
+	requestSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "zoneupdated_http_request_duration_seconds",
+		Help: "Time taken to handle HTTP requests, by endpoint and status.",
+	}, []string{"endpoint", "status"})
+	loadedTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zoneupdated_load_success_timestamp_seconds",
+		Help: "When files were last loaded or reloaded successfully, by file.",
+	}, []string{"file"})
+	reloadFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoneupdated_reload_failures_total",
+		Help: "Failed reloads of files on SIGHUP, by file. The files loaded before are kept in use.",
+	}, []string{"file"})
+
+	rateLimitBuckets = prometheus.NewDesc("zoneupdated_rate_limit_buckets",
+		"Keys using some of their rate limit, by limit.", []string{"limit"}, nil)
+	lockedOut = prometheus.NewDesc("zoneupdated_locked_out",
+		"Client addresses that are locked out, and users whose attempts are being refused for a while, by kind.",
+		[]string{"kind"}, nil)
+
+	// limitsApi is the API whose rate limiters and lockouts are collected
+	limitsApi atomic.Value
+)
+
+func init() {
+	prometheus.MustRegister(limitsCollector{})
+}
+
+// observeRequest records a request that has been handled.
+func observeRequest(r *http.Request, status int, duration time.Duration) {
+	endpoint := "unmatched"
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		endpoint = r.Method + " " + rctx.RoutePattern()
+	}
+
+	requestsTotal.WithLabelValues(endpoint, strconv.Itoa(status)).Inc()
+	requestSeconds.WithLabelValues(endpoint, strconv.Itoa(status)).Observe(duration.Seconds())
+}
+
+// loaded records that file, such as the certificates, was loaded successfully.
+func loaded(file string) {
+	loadedTimestamp.WithLabelValues(file).SetToCurrentTime()
+}
+
+// reloadFailed records that reloading file failed, and the daemon is still
+// using what it loaded before.
+func reloadFailed(file string) {
+	reloadFailures.WithLabelValues(file).Inc()
+}
+
+// collectLimits makes the state of the rate limiters and lockouts available
+// as metrics.
+func (api *RestApi) collectLimits() {
+	limitsApi.Store(api)
+}
+
+// limitsCollector collects the state of the rate limiters and lockouts when
+// the metrics are scraped.
+type limitsCollector struct{}
+
+func (limitsCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- rateLimitBuckets
+	descs <- lockedOut
+}
+
+func (limitsCollector) Collect(metrics chan<- prometheus.Metric) {
+	api, ok := limitsApi.Load().(*RestApi)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	limiters := []*ratelimit.Limiter{api.ipLimit, api.userLimit, api.updater.NameLimiter()}
+	for i, limit := range []string{"ip", "user", "name"} {
+		if limiters[i] != nil {
+			metrics <- prometheus.MustNewConstMetric(rateLimitBuckets, prometheus.GaugeValue,
+				float64(len(limiters[i].Buckets(now))), limit)
+		}
+	}
+
+	if api.lockouts != nil {
+		lockouts := []*ratelimit.Lockout{api.lockouts, api.userBackoff}
+		for i, kind := range []string{"ip", "user"} {
+			count := 0
+			for _, locked := range lockouts[i].Locked(now) {
+				if locked.LockedUntil != nil {
+					count++
+				}
+			}
+			metrics <- prometheus.MustNewConstMetric(lockedOut, prometheus.GaugeValue, float64(count), kind)
+		}
+	}
+}
+
+// MetricsHandler serves the metrics, and the health and readiness checks.
+func (api *RestApi) MetricsHandler() http.Handler {
+	r := chi.NewRouter()
+
+	r.Handle("/metrics", promhttp.Handler())
+	r.Get("/healthz", healthz)
+	r.Get("/readyz", api.readyz)
+
+	return r
+}
@@ -0,0 +1,186 @@
+package restapi
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+// openApiDocument describes the v2 API. URL_PREFIX is replaced by the configured --url-prefix.
+const openApiDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "zoneupdated",
+    "description": "Resource oriented API for the records in a zone file",
+    "version": "2"
+  },
+  "servers": [{"url": "URL_PREFIX/v2"}],
+  "security": [{"basicAuth": []}, {"bearerAuth": []}],
+  "paths": {
+    "/zones/{zone}/rrsets/{name}/{type}": {
+      "parameters": [
+        {"name": "zone", "in": "path", "required": true, "description": "Name of the zone", "schema": {"type": "string"}},
+        {"name": "name", "in": "path", "required": true,
+         "description": "Name relative to the zone, @ for the zone itself, or fully qualified with a trailing dot",
+         "schema": {"type": "string"}},
+        {"name": "type", "in": "path", "required": true, "description": "Record type, eg A or TXT", "schema": {"type": "string"}}
+      ],
+      "get": {
+        "summary": "Get the records with a name and type, including disabled ones",
+        "responses": {
+          "200": {"$ref": "#/components/responses/RRset"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "put": {
+        "summary": "Replace the records with a name and type",
+        "parameters": [{"$ref": "#/components/parameters/IfMatch"}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RRsetPut"}}}
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/RRset"},
+          "204": {"description": "The RRset was replaced with no records"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "412": {"$ref": "#/components/responses/PreconditionFailed"}
+        }
+      },
+      "patch": {
+        "summary": "Change the TTL, value or whether they are enabled of all of the existing records with a name and type",
+        "parameters": [{"$ref": "#/components/parameters/IfMatch"}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RRsetPatch"}}}
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/RRset"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "412": {"$ref": "#/components/responses/PreconditionFailed"}
+        }
+      },
+      "delete": {
+        "summary": "Remove the records with a name and type from the zone file",
+        "parameters": [{"$ref": "#/components/parameters/IfMatch"}],
+        "responses": {
+          "204": {"description": "The records were removed"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "412": {"$ref": "#/components/responses/PreconditionFailed"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "basicAuth": {"type": "http", "scheme": "basic"},
+      "bearerAuth": {"type": "http", "scheme": "bearer", "description": "A token from --token-file"}
+    },
+    "parameters": {
+      "IfMatch": {
+        "name": "If-Match", "in": "header", "required": false,
+        "description": "Only make the change if the zone serial still matches this ETag, or with * if the RRset exists",
+        "schema": {"type": "string"}
+      }
+    },
+    "responses": {
+      "RRset": {
+        "description": "The RRset",
+        "headers": {"ETag": {"description": "The zone serial, quoted", "schema": {"type": "string"}}},
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RRset"}}}
+      },
+      "Error": {
+        "description": "An error",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "PreconditionFailed": {
+        "description": "The If-Match precondition failed",
+        "headers": {"ETag": {"description": "The current zone serial, quoted", "schema": {"type": "string"}}},
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PreconditionFailed"}}}
+      }
+    },
+    "schemas": {
+      "Record": {
+        "type": "object",
+        "required": ["value"],
+        "properties": {
+          "value": {"type": "string"},
+          "enabled": {"type": "boolean", "description": "Disabled records are commented out in the zone file", "default": true}
+        }
+      },
+      "RRset": {
+        "type": "object",
+        "properties": {
+          "zone": {"type": "string"},
+          "name": {"type": "string", "description": "Fully qualified name"},
+          "type": {"type": "string"},
+          "ttl": {"type": "integer", "format": "int32"},
+          "serial": {"type": "integer", "format": "int64"},
+          "records": {"type": "array", "items": {"$ref": "#/components/schemas/Record"}}
+        }
+      },
+      "RRsetPut": {
+        "type": "object",
+        "properties": {
+          "ttl": {"type": "integer", "format": "int32", "description": "TTL of the records, or 0 for the zone default"},
+          "records": {"type": "array", "items": {"$ref": "#/components/schemas/Record"}}
+        }
+      },
+      "RRsetPatch": {
+        "type": "object",
+        "properties": {
+          "ttl": {"type": "integer", "format": "int32"},
+          "value": {"type": "string"},
+          "enabled": {"type": "boolean"}
+        }
+      },
+      "UpdateResult": {
+        "type": "object",
+        "description": "Response of the present and cleanup endpoints outside v2, with Accept: application/json or ?dry_run=1",
+        "properties": {
+          "changed": {"type": "boolean"},
+          "serial": {"type": "integer", "format": "int64"},
+          "lines": {"type": "array", "items": {"type": "integer"}, "description": "Numbers of the matched lines, counting from 1"},
+          "matched": {"type": "array", "items": {"type": "string"}, "description": "The matched lines, as they are after the update"},
+          "diff": {"type": "string", "description": "Unified diff of the zone file, for dry runs"}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "properties": {
+          "error": {"type": "string", "description": "Human readable description of the error"},
+          "code": {"$ref": "#/components/schemas/ErrorCode"}
+        }
+      },
+      "ErrorCode": {
+        "type": "string",
+        "enum": ["RECORD_NOT_FOUND", "ZONE_NOT_FOUND", "LOCK_TIMEOUT", "INVALID_VALUE", "INVALID_REQUEST",
+                 "FORBIDDEN", "FORBIDDEN_NAME", "FORBIDDEN_TYPE", "FORBIDDEN_OPERATION", "FORBIDDEN_ADDRESS",
+                 "UNAUTHORIZED", "PRECONDITION_FAILED", "CONFLICT",
+                 "IDEMPOTENCY_KEY_REUSED", "RATE_LIMITED", "LOCKED_OUT", "INTERNAL_ERROR"]
+      },
+      "PreconditionFailed": {
+        "type": "object",
+        "properties": {
+          "error": {"type": "string"},
+          "code": {"$ref": "#/components/schemas/ErrorCode"},
+          "current": {"$ref": "#/components/schemas/RRset"}
+        }
+      }
+    }
+  }
+}
+`
+
+func (api *RestApi) openApi(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = io.WriteString(w, strings.Replace(openApiDocument, "URL_PREFIX", strings.TrimSuffix(api.conf.UrlPrefix, "/"), 1))
+}
@@ -3,37 +3,65 @@ package restapi
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 	"sync/atomic"
+	"zoneupdated/authz"
+	"zoneupdated/passwd"
 )
 
 type User struct {
 	Username string
-	Password string
+	Password passwd.Hash
+	// Policy restricts what the user may do, or is nil if it isn't restricted
+	Policy *authz.Policy
 }
 
 type userMap map[string]User
 
 type PasswordFile struct {
-	filename    string
-	credentials atomic.Value
+	filename       string
+	allowPlaintext bool
+	credentials    atomic.Value
 }
 
-func (p *PasswordFile) CheckPassword(_ context.Context, username string, password string) (bool, context.Context) {
+// dummyHash is a bcrypt hash at the default cost, checked for users who don't
+// exist so that they take as long to refuse as a wrong password, and the
+// timing doesn't reveal which users exist.
+var dummyHash, _ = passwd.Parse("$2a$10$hwA1XVN1Mpoh9dmM28608exU4VuGM5.ZZnfjA5HlP0GxbQFAoCYbm")
+
+func (p *PasswordFile) CheckPassword(ctx context.Context, username string, password string) (bool, context.Context) {
 	users := p.credentials.Load().(userMap)
 	user, ok := users[username]
-	if ok {
-		if password == user.Password {
-			return true, nil
-		}
+	if !ok {
+		dummyHash.Verify(password)
+		return false, nil
 	}
-	return false, nil
+	if !user.Password.Verify(password) {
+		return false, nil
+	}
+
+	return true, authz.NewContext(ctx, authz.Principal{Name: user.Username, Policy: user.Policy})
 }
 
-func NewPasswordFile(filename string) (*PasswordFile, error) {
-	p := PasswordFile{filename: filename}
+// Principal returns the principal for a user authenticated some other way, such
+// as by a client certificate, if the user is in the file.
+func (p *PasswordFile) Principal(username string) (authz.Principal, bool) {
+	users := p.credentials.Load().(userMap)
+	user, ok := users[username]
+	if !ok {
+		return authz.Principal{}, false
+	}
+
+	return authz.Principal{Name: user.Username, Policy: user.Policy}, true
+}
+
+// NewPasswordFile loads users from filename. Their passwords must be hashed
+// unless allowPlaintext is set.
+func NewPasswordFile(filename string, allowPlaintext bool) (*PasswordFile, error) {
+	p := PasswordFile{filename: filename, allowPlaintext: allowPlaintext}
 	err := p.loadFile(filename)
 	return &p, err
 }
@@ -42,6 +70,9 @@ func (p *PasswordFile) Reload() error {
 	return p.loadFile(p.filename)
 }
 
+// loadFile reads users one per line, either as the user name and then the
+// password delimited by whitespace, or as user:password as in an htpasswd
+// file. Either may be followed by an authorization policy.
 func (p *PasswordFile) loadFile(filename string) error {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -51,24 +82,48 @@ func (p *PasswordFile) loadFile(filename string) error {
 
 	scanner := bufio.NewScanner(file)
 	scanner.Split(bufio.ScanLines)
-	lineNumber := 1
+	lineNumber := 0
 
 	credentials := make(userMap)
 
 	for scanner.Scan() {
 		line := scanner.Text()
-		fields := strings.Fields(line)
+		lineNumber++
 
-		if len(fields) == 0 {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
 			continue
-		} else if len(fields) == 2 {
-			user := User{Username: fields[0], Password: fields[1]}
-			credentials[user.Username] = user
-		} else {
-			return fmt.Errorf("entry needs two fields at line %d: '%s'", lineNumber, line)
 		}
 
-		lineNumber++
+		// User names can't contain colons in HTTP Basic Authentication
+		if parts := strings.SplitN(fields[0], ":", 2); len(parts) == 2 {
+			fields = append(parts, fields[1:]...)
+		}
+
+		if len(fields) < 2 || fields[0] == "" {
+			return fmt.Errorf("entry needs a user and a password at line %d: '%s'", lineNumber, line)
+		}
+
+		hash, err := passwd.Parse(fields[1])
+		if errors.Is(err, passwd.ErrUnknownFormat) && p.allowPlaintext {
+			hash = passwd.Plaintext(fields[1])
+		} else if errors.Is(err, passwd.ErrUnknownFormat) {
+			return fmt.Errorf("password of %s at line %d isn't hashed, hash it or use --http-auth-plaintext",
+				fields[0], lineNumber)
+		} else if err != nil {
+			return fmt.Errorf("password of %s at line %d: %s", fields[0], lineNumber, err)
+		}
+
+		policy, err := authz.ParsePolicy(fields[2:])
+		if err != nil {
+			return fmt.Errorf("invalid policy at line %d: %s", lineNumber, err)
+		}
+
+		credentials[fields[0]] = User{Username: fields[0], Password: hash, Policy: policy}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
 	}
 
 	p.credentials.Store(credentials)
@@ -4,13 +4,15 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 	"zoneupdated/restapi"
 )
 
 func TestPasswordFile_CheckPassword(t *testing.T) {
-	p, err := restapi.NewPasswordFile("testdata/test_passwd")
+	p, err := restapi.NewPasswordFile("testdata/test_passwd", true)
 	if err != nil {
 		t.Fatalf("Could not open password file: %s", err)
 	}
@@ -25,7 +27,7 @@ func TestPasswordFile_CheckPassword(t *testing.T) {
 }
 
 func TestPasswordFile_DoesntExist(t *testing.T) {
-	_, err := restapi.NewPasswordFile("testdata/NoSuchFile.oops")
+	_, err := restapi.NewPasswordFile("testdata/NoSuchFile.oops", true)
 	if err == nil {
 		t.Error("Should have failed to open nonexistent password file")
 	}
@@ -39,7 +41,7 @@ func TestPasswordFile_Reload(t *testing.T) {
 	}
 	defer os.Remove(tempFile)
 
-	p, err := restapi.NewPasswordFile(tempFile)
+	p, err := restapi.NewPasswordFile(tempFile, true)
 	if err != nil {
 		t.Fatalf("Could not open password file: %s", err)
 	}
@@ -76,6 +78,41 @@ func TestPasswordFile_Reload(t *testing.T) {
 	tryLogin(t, p, "user3", "password3", true)
 }
 
+func TestPasswordFile_Htpasswd(t *testing.T) {
+	p, err := restapi.NewPasswordFile("testdata/htpasswd", false)
+	if err != nil {
+		t.Fatalf("Could not open password file: %s", err)
+	}
+
+	tryLogin(t, p, "user1", "secret", true)
+	tryLogin(t, p, "user2", "secret", true)
+	tryLogin(t, p, "user3", "secret", true)
+	tryLogin(t, p, "user4", "Hello world!", true)
+	tryLogin(t, p, "user5", "secret", true)
+	tryLogin(t, p, "user1", "Secret", false)
+	tryLogin(t, p, "user1", "$2y$04$swLrX5JrFAtYnfKpjBZmnuh9lZcAcRVbRvwKz6SYzngVppx6aaVmG", false)
+}
+
+func TestPasswordFile_Plaintext(t *testing.T) {
+	tempFile := fmt.Sprintf("%s%c%d.passwd", os.TempDir(), os.PathSeparator, os.Getpid())
+	err := ioutil.WriteFile(tempFile, []byte("\n# Users\n\nuser1 password1\n"), 0600)
+	if err != nil {
+		t.Fatalf("Unable to create temporary password file %s: %s", tempFile, err)
+	}
+	defer os.Remove(tempFile)
+
+	_, err = restapi.NewPasswordFile(tempFile, false)
+	if err == nil || !strings.Contains(err.Error(), "line 4") {
+		t.Errorf("Expected plaintext password at line 4 to be refused but got %v", err)
+	}
+
+	p, err := restapi.NewPasswordFile(tempFile, true)
+	if err != nil {
+		t.Fatalf("Could not open password file: %s", err)
+	}
+	tryLogin(t, p, "user1", "password1", true)
+}
+
 func tryLogin(t *testing.T, p *restapi.PasswordFile, user string, password string, expectOk bool) {
 	ctx := context.TODO()
 
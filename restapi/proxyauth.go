@@ -0,0 +1,85 @@
+package restapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"zoneupdated/authz"
+	"zoneupdated/httperror"
+	"zoneupdated/logging"
+)
+
+type peerAddrKey struct{}
+
+// rememberPeer keeps the address of the peer the request came from, before
+// RealIP replaces it with the address the proxy says the client has.
+func rememberPeer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), peerAddrKey{}, r.RemoteAddr)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// peerIP returns the address of the peer the request came from, which is the
+// proxy if there is one.
+func peerIP(r *http.Request) net.IP {
+	addr, ok := r.Context().Value(peerAddrKey{}).(string)
+	if !ok {
+		addr = r.RemoteAddr
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	return net.ParseIP(host)
+}
+
+func (api *RestApi) trustedProxy(ip net.IP) bool {
+	return contains(api.proxies, ip)
+}
+
+// proxyAuth authenticates requests by the user an authenticating proxy puts in
+// the --proxy-auth-header, which is only believed from the trusted proxies.
+// Requests without the header, or from anywhere else, are passed to fallback,
+// if there is one, and are otherwise refused.
+func (api *RestApi) proxyAuth(fallback func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		var unauthenticated http.Handler
+		if fallback != nil {
+			unauthenticated = fallback(next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity := strings.TrimSpace(r.Header.Get(api.conf.ProxyAuthHeader))
+			trusted := api.trustedProxy(peerIP(r))
+			if identity != "" && !trusted {
+				logging.FromContext(r.Context()).Warn("Ignoring proxy auth header from untrusted peer", "header", api.conf.ProxyAuthHeader, "peer", peerIP(r))
+			}
+
+			if identity == "" || !trusted {
+				if unauthenticated != nil {
+					unauthenticated.ServeHTTP(w, r)
+				} else {
+					writeError(w, r, httperror.Error(http.StatusUnauthorized, errors.New("Unauthorized")))
+				}
+				return
+			}
+
+			principal := authz.Principal{Name: identity}
+			if api.passwords != nil {
+				var ok bool
+				if principal, ok = api.passwords.Principal(identity); !ok {
+					logging.FromContext(r.Context()).Warn("Proxy authenticated unknown user", "user", identity)
+					writeError(w, r, httperror.Error(http.StatusUnauthorized, errors.New("Unauthorized")))
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(authz.NewContext(r.Context(), principal)))
+		})
+	}
+}
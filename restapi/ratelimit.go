@@ -0,0 +1,144 @@
+package restapi
+
+import (
+	"errors"
+	"github.com/go-chi/chi/middleware"
+	"net/http"
+	"strconv"
+	"time"
+	"zoneupdated/authz"
+	"zoneupdated/logging"
+	"zoneupdated/ratelimit"
+)
+
+// limitIP rate limits requests from each client address.
+func (api *RestApi) limitIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := api.ipLimit.Allow(clientIP(r).String(), time.Now()); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitUser rate limits requests by each authenticated user.
+func (api *RestApi) limitUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal, ok := authz.FromContext(r.Context()); ok {
+			if err := api.userLimit.Allow(principal.Name, time.Now()); err != nil {
+				writeError(w, r, err)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxUserBackoff is the longest a user is refused for after authentication
+// failures.
+const maxUserBackoff = time.Minute
+
+// lockout refuses requests from client addresses with too many recent
+// authentication failures, which are counted from the 401 responses of next,
+// however it authenticates requests. Only requests that present credentials
+// count, so the challenges sent to clients that haven't yet don't. Users
+// aren't locked out themselves, so that nobody can lock out a user just by
+// knowing their name, but after as many failures their further attempts are
+// refused for a while, doubling with each failure up to maxUserBackoff, to
+// slow down guessing from many addresses.
+func (api *RestApi) lockout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		key := "ip:" + clientIP(r).String()
+		userKey := ""
+		if user := authUser(r); user != "" {
+			userKey = "user:" + user
+		}
+
+		if err := api.lockouts.Check(key, now); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if userKey != "" {
+			if err := api.userBackoff.Check(userKey, now); err != nil {
+				writeError(w, r, err)
+				return
+			}
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		if ww.Status() != http.StatusUnauthorized {
+			if userKey != "" {
+				api.userBackoff.Reset(userKey, now)
+			}
+			return
+		}
+
+		if presentsCredentials(r) && api.lockouts.Fail(key, now) {
+			logging.FromContext(r.Context()).Warn("Locked out after too many authentication failures", "key", key)
+		}
+		if userKey != "" && api.userBackoff.Fail(userKey, now) {
+			logging.FromContext(r.Context()).Warn("Backing off after too many authentication failures", "key", userKey)
+		}
+	})
+}
+
+// authUser returns the user a request claims to be from, before it is
+// authenticated.
+func authUser(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok {
+		return user
+	}
+
+	return r.Header.Get("X-Api-User")
+}
+
+// presentsCredentials checks whether a request has credentials of any kind,
+// rather than none at all.
+func presentsCredentials(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get("X-Api-User") != "" || r.Header.Get("X-Api-Key") != "" ||
+		r.TLS != nil && len(r.TLS.PeerCertificates) > 0
+}
+
+// setRetryAfter sets the Retry-After header if err is from a rate limit.
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var limited *ratelimit.Error
+	if errors.As(err, &limited) {
+		w.Header().Set("Retry-After", strconv.FormatInt(limited.RetryAfterSeconds(), 10))
+	}
+}
+
+type limitsResponse struct {
+	IP       []ratelimit.Bucket `json:"ip"`
+	User     []ratelimit.Bucket `json:"user"`
+	Name     []ratelimit.Bucket `json:"name"`
+	Lockouts []ratelimit.Locked `json:"lockouts"`
+}
+
+// getLimits shows the rate limits that have been used, and lockouts.
+func (api *RestApi) getLimits(w http.ResponseWriter, _ *http.Request) {
+	now := time.Now()
+	buckets := func(limiter *ratelimit.Limiter) []ratelimit.Bucket {
+		if limiter == nil {
+			return []ratelimit.Bucket{}
+		}
+		return limiter.Buckets(now)
+	}
+
+	response := limitsResponse{
+		IP:       buckets(api.ipLimit),
+		User:     buckets(api.userLimit),
+		Name:     buckets(api.updater.NameLimiter()),
+		Lockouts: []ratelimit.Locked{},
+	}
+	if api.lockouts != nil {
+		response.Lockouts = append(api.lockouts.Locked(now), api.userBackoff.Locked(now)...)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
@@ -1,28 +1,71 @@
 package restapi
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	mymiddleware "github.com/tsarna/chi/middleware"
 	"io"
-	"log"
+	"mime"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
+	"zoneupdated/audit"
+	"zoneupdated/authz"
 	"zoneupdated/config"
+	"zoneupdated/hmacauth"
 	"zoneupdated/httperror"
+	"zoneupdated/idempotency"
+	"zoneupdated/jwt"
+	"zoneupdated/logging"
+	"zoneupdated/ratelimit"
+	"zoneupdated/tokens"
 	"zoneupdated/updater"
 )
 
 type RestApi struct {
-	conf        config.Config
-	updater     updater.Updater
-	credentials map[string]string
-	cert        atomic.Value
-	passwords   *PasswordFile
+	conf          config.Config
+	updater       updater.Updater
+	credentials   map[string]string
+	cert          atomic.Value
+	clientCAs     atomic.Value
+	passwords     *PasswordFile
+	authenticator mymiddleware.Authenticator
+	acmeDns       *AcmeDnsAccounts
+	idempotency   *idempotency.Store
+	tokens        *tokens.Store
+	proxies       []*net.IPNet
+	allowFrom     []*net.IPNet
+	jwt           *jwt.Verifier
+	hmacKeys      *hmacauth.KeyFile
+	replays       *hmacauth.Replays
+	ipLimit       *ratelimit.Limiter
+	userLimit     *ratelimit.Limiter
+	lockouts      *ratelimit.Lockout
+	userBackoff   *ratelimit.Lockout
+	auditLog      *audit.Log
+}
+
+// credentialsAuthenticator checks passwords against a map of users to passwords.
+type credentialsAuthenticator map[string]string
+
+func (credentials credentialsAuthenticator) CheckPassword(ctx context.Context, username string, password string) (bool, context.Context) {
+	expected, ok := credentials[username]
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(expected)) != 1 {
+		return false, nil
+	}
+
+	return true, authz.NewContext(ctx, authz.Principal{Name: username})
 }
 
 func New(conf config.Config, updater updater.Updater) RestApi {
@@ -30,28 +73,176 @@ func New(conf config.Config, updater updater.Updater) RestApi {
 }
 
 func (api *RestApi) ServeHttp() error {
+	r, err := api.Handler()
+	if err != nil {
+		return err
+	}
+
+	if api.conf.MetricsAddr != "" {
+		go func() {
+			err := http.ListenAndServe(api.conf.MetricsAddr, api.MetricsHandler())
+			logging.Default().Fatal("Unable to serve metrics", "error", err)
+		}()
+	}
+
+	if api.conf.ExternalDnsAddr != "" {
+		go func() {
+			err := http.ListenAndServe(api.conf.ExternalDnsAddr, api.ExternalDnsHandler())
+			logging.Default().Fatal("Unable to serve the external-dns webhook provider", "error", err)
+		}()
+	}
+
+	if api.conf.UseHttps() {
+		err := api.loadCert()
+		if err != nil {
+			return err
+		}
+
+		tlsConfig := &tls.Config{
+			GetCertificate: api.getCertificate,
+		}
+		if api.conf.TlsClientCA != "" {
+			tlsConfig.GetConfigForClient = api.getConfigForClient
+		}
+		server := &http.Server{
+			Addr:      api.conf.ListenAddr,
+			Handler:   r,
+			TLSConfig: tlsConfig,
+		}
+		return server.ListenAndServeTLS("", "")
+	} else {
+		return http.ListenAndServe(api.conf.ListenAddr, r)
+	}
+}
+
+// Handler loads the configured credentials and returns the handler for the API.
+func (api *RestApi) Handler() (http.Handler, error) {
 	var err error
 
 	if api.conf.HttpAuthFile != "" {
-		api.passwords, err = NewPasswordFile(api.conf.HttpAuthFile)
+		api.passwords, err = NewPasswordFile(api.conf.HttpAuthFile, api.conf.HttpAuthPlaintext)
 		if err != nil {
-			return fmt.Errorf("while parsing auth file: %s", err)
+			return nil, fmt.Errorf("while parsing auth file: %s", err)
 		}
+		loaded("auth_file")
 	}
 
 	if api.conf.User != "" && api.conf.Password != "" {
 		api.credentials[api.conf.User] = api.conf.Password
 	}
 
+	// Second test ensures auth is enabled even if auth file is empty, to fail secure
+	if api.conf.HttpAuthFile != "" {
+		api.authenticator = api.passwords
+	} else if len(api.credentials) > 0 {
+		api.authenticator = credentialsAuthenticator(api.credentials)
+	}
+
+	if api.conf.TokenFile != "" {
+		api.tokens, err = tokens.New(api.conf.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("while loading tokens: %s", err)
+		}
+		loaded("tokens")
+	}
+
+	if api.conf.HmacKeyFile != "" {
+		api.hmacKeys, err = hmacauth.NewKeyFile(api.conf.HmacKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("while loading HMAC keys: %s", err)
+		}
+		loaded("hmac_keys")
+		api.replays = hmacauth.NewReplays(time.Second * time.Duration(api.conf.HmacWindow))
+	}
+
+	if api.conf.JwtJwks != "" {
+		api.jwt, err = jwt.NewVerifier(api.conf.JwtJwks, api.conf.JwtIssuer, api.conf.JwtAudience)
+		if err != nil {
+			return nil, err
+		}
+		loaded("jwks")
+	}
+
+	if api.conf.TlsClientCA != "" {
+		if err = api.loadClientCAs(); err != nil {
+			return nil, fmt.Errorf("while loading client CA certificates: %s", err)
+		}
+	}
+
+	if api.conf.HttpAllowFrom != "" {
+		api.allowFrom, err = parseNetworks(api.conf.HttpAllowFrom)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing allowed CIDRs: %s", err)
+		}
+	}
+
+	if api.conf.ProxyAuthHeader != "" {
+		api.proxies, err = parseNetworks(api.conf.ProxyAuthFrom)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing proxy CIDRs: %s", err)
+		}
+	}
+
+	if api.conf.RateLimitIP > 0 {
+		api.ipLimit = ratelimit.New("client", api.conf.RateLimitIP)
+	}
+	if api.conf.RateLimitUser > 0 {
+		api.userLimit = ratelimit.New("user", api.conf.RateLimitUser)
+	}
+	if api.conf.LockoutFailures > 0 {
+		api.lockouts = ratelimit.NewLockout(api.conf.LockoutFailures, time.Second*time.Duration(api.conf.LockoutWindow),
+			time.Second*time.Duration(api.conf.LockoutDuration))
+		api.userBackoff = ratelimit.NewBackoff(api.conf.LockoutFailures, time.Second*time.Duration(api.conf.LockoutWindow),
+			maxUserBackoff)
+	}
+
+	if api.conf.AcmeDnsFile != "" {
+		api.acmeDns, err = NewAcmeDnsAccounts(api.conf.AcmeDnsFile)
+		if err != nil {
+			return nil, fmt.Errorf("while loading acme-dns accounts: %s", err)
+		}
+	}
+
+	if api.conf.IdempotencyWindow > 0 {
+		dir := api.conf.IdempotencyDir
+		if dir == "" {
+			dir = api.conf.ZoneFileName + ".idempotency"
+		}
+		api.idempotency = idempotency.New(dir, time.Second*time.Duration(api.conf.IdempotencyWindow))
+
+		// The default is next to the zone file, which can't be written when
+		// the zone file alone is bind mounted into a container
+		if err = api.idempotency.Check(); err != nil && api.conf.IdempotencyDir != "" {
+			return nil, fmt.Errorf("while checking idempotency dir: %s", err)
+		} else if err != nil {
+			logging.Default().Warn("Idempotency keys are disabled, as the default directory for them can't be written, "+
+				"set --idempotency-dir to enable them", "dir", dir, "error", err)
+			api.idempotency = nil
+		}
+	}
+
+	if api.conf.AuditLog != "" {
+		api.auditLog, err = audit.Open(api.conf.AuditLog, int64(api.conf.AuditLogMaxSize)*1024*1024, api.conf.AuditLogBackups)
+		if err != nil {
+			return nil, fmt.Errorf("while opening audit log: %s", err)
+		}
+		api.updater.SetAuditLog(api.auditLog)
+	}
+
+	api.collectLimits()
+
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
+	r.Use(rememberPeer)
 
 	if api.conf.TrustProxy {
 		r.Use(middleware.RealIP)
 	}
 
-	r.Use(middleware.Logger)
+	r.Use(auditRequest)
+
+	r.Use(logRequests)
 	r.Use(middleware.Recoverer)
 
 	// Set a timeout value on the request context (ctx), that will signal
@@ -59,42 +250,98 @@ func (api *RestApi) ServeHttp() error {
 	// processing should be stopped.
 	r.Use(middleware.Timeout(time.Second * time.Duration(api.conf.HttpTimeoutSecs)))
 
+	if api.allowFrom != nil {
+		r.Use(api.checkAllowFrom)
+	}
+
+	if api.ipLimit != nil {
+		r.Use(api.limitIP)
+	}
+
+	// Endpoints that authenticate requests themselves are locked out too
+	var lockout []func(http.Handler) http.Handler
+	if api.lockouts != nil {
+		lockout = append(lockout, api.lockout)
+	}
+
+	// Each way of authenticating falls back to the ones before it
+	var auth func(http.Handler) http.Handler
+	if api.authenticator != nil {
+		auth = mymiddleware.BasicAuthWithAuthenticator(api.conf.HttpAuthRealm, api.authenticator)
+	}
+	if api.tokens != nil {
+		auth = api.tokenAuth(auth)
+	}
+	if api.jwt != nil {
+		auth = api.jwtAuth(auth)
+	}
+	if api.hmacKeys != nil {
+		auth = api.hmacAuth(auth)
+	}
+	if api.conf.TlsClientCA != "" {
+		auth = api.certAuth(auth)
+	}
+	if api.conf.ProxyAuthHeader != "" {
+		auth = api.proxyAuth(auth)
+	}
+
+	authenticate := append([]func(http.Handler) http.Handler{}, lockout...)
+	if auth != nil {
+		authenticate = append(authenticate, auth)
+	}
+	authenticate = append(authenticate, logPrincipal, checkUserFrom)
+	if api.userLimit != nil {
+		authenticate = append(authenticate, api.limitUser)
+	}
+
 	r.Route(api.conf.UrlPrefix, func(r chi.Router) {
-		// Second test ensures auth is enabled even if auth file is empty, to fail secure
-		if api.conf.HttpAuthFile != "" {
-			r.Use(mymiddleware.BasicAuthWithAuthenticator(api.conf.HttpAuthRealm, api.passwords))
-		} else if len(api.credentials) > 0 {
-			r.Use(mymiddleware.BasicAuth(api.conf.HttpAuthRealm, api.credentials))
+		// acme-dns clients authenticate their updates with their own headers
+		if api.acmeDns != nil {
+			r.With(lockout...).Post("/acme-dns/update", api.acmeDnsUpdate)
+			r.Get("/acme-dns/health", acmeDnsHealth)
 		}
 
-		r.Post("/present", api.presentEntry)
-		r.Post("/cleanup", api.disableEntry)
+		r.Get("/v2/openapi.json", api.openApi)
+
+		r.Group(func(r chi.Router) {
+			r.Use(authenticate...)
+
+			r.With(api.idempotent).Post("/present", api.presentEntry)
+			r.With(api.idempotent).Post("/cleanup", api.disableEntry)
+
+			r.Get("/serial", api.getSerial)
+			r.Get("/records", api.getRecords)
+			r.Get("/records/{fqdn}", api.getRecord)
+			r.Get("/records/{fqdn}/{rrtype}", api.getRecord)
+
+			r.Post("/delegations", api.postDelegations)
+
+			r.Route("/v2", api.v2Routes)
+
+			if api.acmeDns != nil {
+				r.Post("/acme-dns/register", api.acmeDnsRegister)
+			}
+
+			if api.tokens != nil {
+				r.With(requireAdmin).Route("/admin/tokens", api.adminTokenRoutes)
+			}
+
+			if api.ipLimit != nil || api.userLimit != nil || api.updater.NameLimiter() != nil || api.lockouts != nil {
+				r.With(requireAdmin).Get("/admin/limits", api.getLimits)
+			}
+		})
 	})
 
-	if api.conf.RobotsTxt {
-		r.Get("/robots.txt", robotsTxt)
+	// Routers expect the dyndns2 endpoint at a fixed path
+	if api.conf.DynDns2 {
+		r.With(dynDns2Refusals).With(authenticate...).Get("/nic/update", api.dynDns2Update)
 	}
 
-	if api.conf.UseHttps() {
-		err := api.loadCert()
-		if err != nil {
-			return err
-		}
-
-		tlsConfig := &tls.Config{
-			GetCertificate: api.getCertificate,
-		}
-		server := &http.Server{
-			Addr:      api.conf.ListenAddr,
-			Handler:   r,
-			TLSConfig: tlsConfig,
-		}
-		log.Fatal(server.ListenAndServeTLS("", ""))
-	} else {
-		log.Fatal(http.ListenAndServe(api.conf.ListenAddr, r))
+	if api.conf.RobotsTxt {
+		r.Get("/robots.txt", robotsTxt)
 	}
 
-	return nil
+	return r, nil
 }
 
 func (api *RestApi) presentEntry(w http.ResponseWriter, r *http.Request) {
@@ -105,40 +352,205 @@ func (api *RestApi) disableEntry(w http.ResponseWriter, r *http.Request) {
 	api.updateEntry(w, r, true)
 }
 
+// legoRequest is the body of a Lego httpreq request. In the default mode it is
+// just an UpdateRequest, but in RAW mode Lego sends the domain and key
+// authorization instead, and the record name and value are derived from them.
+// The key authorization is the token and the account key's thumbprint, so a
+// token that isn't its first part means the request is confused.
+type legoRequest struct {
+	updater.UpdateRequest
+	Domain  string `json:"domain"`
+	Token   string `json:"token"`
+	KeyAuth string `json:"keyAuth"`
+}
+
 func (api *RestApi) updateEntry(w http.ResponseWriter, r *http.Request, disable bool) {
-	updateRequest := updater.UpdateRequest{RRType: "TXT"}
+	request := legoRequest{UpdateRequest: updater.UpdateRequest{RRType: "TXT"}}
 
 	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&updateRequest); err != nil {
-		http.Error(w, fmt.Sprint("JSON Parse error: ", err), http.StatusBadRequest)
+	if err := decoder.Decode(&request); err != nil {
+		writeError(w, r, badRequest("JSON Parse error: %s", err))
 		return
 	}
 
+	if request.FQDN == "" && request.Domain != "" {
+		if request.KeyAuth == "" {
+			writeError(w, r, badRequest("keyAuth not provided"))
+			return
+		}
+		if request.Token != "" && !strings.HasPrefix(request.KeyAuth, request.Token+".") {
+			writeError(w, r, badRequest("keyAuth is not for token %s", request.Token))
+			return
+		}
+
+		request.FQDN, request.Value = dns01Record(request.Domain, request.KeyAuth)
+	}
+
+	updateRequest := request.UpdateRequest
 	updateRequest.Disable = disable
+	updateRequest.DryRun = isDryRun(r)
 
 	if updateRequest.FQDN == "" {
-		http.Error(w, "fqdn not provided", http.StatusBadRequest)
+		writeError(w, r, badRequest("fqdn not provided"))
 		return
 	}
 
-	if updateRequest.Value == "" {
-		http.Error(w, "value not provided", http.StatusBadRequest)
+	if err := checkValue(updateRequest.Value); err != nil {
+		writeError(w, r, httperror.WithCode(http.StatusBadRequest, httperror.InvalidValue, err))
 		return
 	}
 
-	err := api.updater.Update(r.Context(), updateRequest)
+	result, err := api.updater.Update(r.Context(), updateRequest)
 	if err != nil {
-		switch s := err.(type) {
-		case httperror.HttpError:
-			http.Error(w, s.Error(), s.HttpStatus())
-		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+		writeError(w, r, err)
+	} else if updateRequest.DryRun || wantsJSON(r) {
+		writeJSON(w, http.StatusOK, result)
 	} else {
 		_, _ = w.Write([]byte("OK\n"))
 	}
 }
 
+// dns01Record returns the name and value of the TXT record for an ACME DNS-01
+// challenge, computed the same way as Lego does.
+func dns01Record(domain string, keyAuth string) (string, string) {
+	domain = strings.TrimPrefix(strings.TrimSuffix(domain, "."), "*.")
+	sum := sha256.Sum256([]byte(keyAuth))
+
+	return fmt.Sprintf("_acme-challenge.%s.", domain), base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// isDryRun checks for a dry_run query parameter such as ?dry_run=1 or ?dry_run=true
+func isDryRun(r *http.Request) bool {
+	dryRun, err := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	return err == nil && dryRun
+}
+
+func (api *RestApi) getSerial(w http.ResponseWriter, r *http.Request) {
+	zone, err := api.updater.Zone(r.Context())
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]uint32{"serial": zone.Serial})
+}
+
+func (api *RestApi) getRecords(w http.ResponseWriter, r *http.Request) {
+	zone, err := api.updater.Zone(r.Context())
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, zone)
+}
+
+func (api *RestApi) getRecord(w http.ResponseWriter, r *http.Request) {
+	rrtype := chi.URLParam(r, "rrtype")
+	if rrtype == "" {
+		rrtype = "TXT"
+	}
+
+	zone, err := api.updater.Lookup(r.Context(), chi.URLParam(r, "fqdn"), rrtype)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, zone)
+}
+
+type delegationRequest struct {
+	FQDNs  []string `json:"fqdns"`
+	RRType string   `json:"rrtype"`
+	Append bool     `json:"append"`
+}
+
+type delegationResponse struct {
+	Serial      uint32               `json:"serial"`
+	Delegations []updater.Delegation `json:"delegations"`
+}
+
+func (api *RestApi) postDelegations(w http.ResponseWriter, r *http.Request) {
+	request := delegationRequest{RRType: "TXT"}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&request); err != nil {
+		writeJSONError(w, badRequest("JSON Parse error: %s", err))
+		return
+	}
+
+	if len(request.FQDNs) == 0 {
+		writeJSONError(w, badRequest("fqdns not provided"))
+		return
+	}
+
+	delegations, result, err := api.updater.Delegations(r.Context(), request.FQDNs, request.RRType, request.Append)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, delegationResponse{Serial: result.Serial, Delegations: delegations})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// errorResponse is the body of JSON error responses. When a precondition
+// fails, Current describes the current state.
+type errorResponse struct {
+	Error   string         `json:"error"`
+	Code    httperror.Code `json:"code"`
+	Current interface{}    `json:"current,omitempty"`
+}
+
+func newErrorResponse(err error) errorResponse {
+	response := errorResponse{Error: err.Error(), Code: httperror.CodeOf(err)}
+
+	var precondition *updater.PreconditionError
+	if errors.As(err, &precondition) {
+		response.Current = precondition
+	}
+
+	return response
+}
+
+func writeJSONError(w http.ResponseWriter, err error) {
+	setRetryAfter(w, err)
+	writeJSON(w, httperror.StatusOf(err), newErrorResponse(err))
+}
+
+// writeError responds with err as JSON if the client asked for it, or else as
+// plain text, which is what Lego expects.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if wantsJSON(r) {
+		writeJSONError(w, err)
+	} else {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), httperror.StatusOf(err))
+	}
+}
+
+func badRequest(format string, a ...interface{}) error {
+	return httperror.WithCode(http.StatusBadRequest, httperror.InvalidRequest, fmt.Errorf(format, a...))
+}
+
+// wantsJSON checks whether the Accept header asks for JSON.
+func wantsJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (api *RestApi) loadCert() error {
 	cert, err := tls.LoadX509KeyPair(api.conf.TlsCertFilename, api.conf.TlsKeyFilename)
 	if err != nil {
@@ -146,6 +558,7 @@ func (api *RestApi) loadCert() error {
 	}
 
 	api.cert.Store(&cert)
+	loaded("certificates")
 
 	return nil
 }
@@ -165,9 +578,22 @@ func (api *RestApi) Reload() {
 		go func() {
 			err := api.loadCert()
 			if err != nil {
-				log.Printf("Failed to reload certificates: %s", err)
+				logging.Default().Error("Failed to reload certificates", "file", api.conf.TlsCertFilename, "error", err)
+				reloadFailed("certificates")
 			} else {
-				log.Printf("Reloaded certificates")
+				logging.Default().Info("Reloaded certificates", "file", api.conf.TlsCertFilename)
+			}
+		}()
+	}
+
+	if api.conf.TlsClientCA != "" {
+		go func() {
+			err := api.loadClientCAs()
+			if err != nil {
+				logging.Default().Error("Failed to reload client CA certificates", "error", err)
+				reloadFailed("client_ca")
+			} else {
+				logging.Default().Info("Reloaded client CA certificates")
 			}
 		}()
 	}
@@ -176,12 +602,72 @@ func (api *RestApi) Reload() {
 		go func() {
 			err := api.passwords.Reload()
 			if err != nil {
-				log.Printf("Failed to reload password file: %s", err)
+				logging.Default().Error("Failed to reload auth file", "file", api.conf.HttpAuthFile, "error", err)
+				reloadFailed("auth_file")
 			} else {
-				log.Printf("Reloaded auth file")
+				logging.Default().Info("Reloaded auth file", "file", api.conf.HttpAuthFile)
+				loaded("auth_file")
 			}
 		}()
 	}
+
+	if api.tokens != nil {
+		go func() {
+			err := api.tokens.Reload()
+			if err != nil {
+				logging.Default().Error("Failed to reload token file", "error", err)
+				reloadFailed("tokens")
+			} else {
+				loaded("tokens")
+			}
+		}()
+	}
+
+	if api.hmacKeys != nil {
+		go func() {
+			err := api.hmacKeys.Reload()
+			if err != nil {
+				logging.Default().Error("Failed to reload HMAC key file", "error", err)
+				reloadFailed("hmac_keys")
+			} else {
+				logging.Default().Info("Reloaded HMAC key file")
+				loaded("hmac_keys")
+			}
+		}()
+	}
+
+	if api.auditLog != nil {
+		go func() {
+			err := api.auditLog.Reopen()
+			if err != nil {
+				logging.Default().Error("Failed to reopen audit log", "error", err)
+			} else {
+				logging.Default().Info("Reopened audit log")
+			}
+		}()
+	}
+
+	if api.jwt != nil {
+		go func() {
+			err := api.jwt.Reload()
+			if err != nil {
+				logging.Default().Error("Failed to reload JWKS", "error", err)
+				reloadFailed("jwks")
+			} else {
+				logging.Default().Info("Reloaded JWKS")
+				loaded("jwks")
+			}
+		}()
+	}
+}
+
+// auditRequest puts the request's ID and client address in its context, for
+// the audit log.
+func auditRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := audit.NewContext(r.Context(), audit.Request{ID: middleware.GetReqID(r.Context()), ClientIP: clientIP(r).String()})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
 }
 
 func robotsTxt(w http.ResponseWriter, _ *http.Request) {
@@ -0,0 +1,222 @@
+package restapi_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"zoneupdated/config"
+	"zoneupdated/restapi"
+	"zoneupdated/updater"
+)
+
+func TestRestApi_Present(t *testing.T) {
+	handler, zoneFile := newTestApi(t, config.Config{})
+	defer removeZoneFile(zoneFile)
+
+	response := request(handler, "POST", "/zone-update/present", `{"fqdn": "test", "rrtype": "A", "value": "192.0.2.2"}`)
+	checkResponse(t, response, http.StatusOK, "OK\n")
+
+	response = request(handler, "POST", "/zone-update/present", `{"fqdn": "nosuchname", "value": "x"}`)
+	checkStatus(t, response, http.StatusBadRequest)
+}
+
+func TestRestApi_PresentJSON(t *testing.T) {
+	handler, zoneFile := newTestApi(t, config.Config{})
+	defer removeZoneFile(zoneFile)
+
+	var result updater.Result
+	response := request(handler, "POST", "/zone-update/present", `{"fqdn": "test", "rrtype": "A", "value": "192.0.2.2"}`,
+		"Accept", "application/json")
+	checkStatus(t, response, http.StatusOK)
+	decodeResponse(t, response, &result)
+	if !result.Changed || result.Serial != 2020053002 || len(result.Lines) != 1 || result.Lines[0] != 12 ||
+		len(result.Matched) != 1 || result.Matched[0] != "test\t\t\tIN A\t\t192.0.2.2" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+
+	var errorResponse struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	response = request(handler, "POST", "/zone-update/present", `{"fqdn": "nosuchname", "value": "x"}`,
+		"Accept", "text/html, application/json;q=0.9")
+	checkStatus(t, response, http.StatusBadRequest)
+	decodeResponse(t, response, &errorResponse)
+	if errorResponse.Code != "RECORD_NOT_FOUND" || errorResponse.Error == "" {
+		t.Errorf("Unexpected error response %+v", errorResponse)
+	}
+
+	response = request(handler, "POST", "/zone-update/present", `{"fqdn": "test", "rrtype": "A", "value": "192.0.2.2\ntest2 IN A 192.0.2.66"}`,
+		"Accept", "application/json")
+	checkStatus(t, response, http.StatusBadRequest)
+	decodeResponse(t, response, &errorResponse)
+	if errorResponse.Code != "INVALID_VALUE" {
+		t.Errorf("Unexpected error response %+v", errorResponse)
+	}
+
+	// Errors are plain text unless JSON is asked for
+	response = request(handler, "POST", "/zone-update/present", `{"fqdn": "test", "rrtype": "A"}`)
+	checkResponse(t, response, http.StatusBadRequest, "value not provided\n")
+}
+
+func TestRestApi_PresentPrecondition(t *testing.T) {
+	handler, zoneFile := newTestApi(t, config.Config{})
+	defer removeZoneFile(zoneFile)
+
+	response := request(handler, "POST", "/zone-update/present",
+		`{"fqdn": "test", "rrtype": "A", "value": "192.0.2.2", "expect_value": "192.0.2.9"}`)
+	checkResponse(t, response, http.StatusPreconditionFailed,
+		"Precondition failed: record does not have value 192.0.2.9 (serial 2020053001, current values [192.0.2.1])\n")
+
+	var errorResponse struct {
+		Code    string `json:"code"`
+		Current struct {
+			Serial  uint32           `json:"serial"`
+			Records []updater.Record `json:"records"`
+		} `json:"current"`
+	}
+	response = request(handler, "POST", "/zone-update/present",
+		`{"fqdn": "test", "rrtype": "A", "value": "192.0.2.2", "if_serial": 2020053000}`, "Accept", "application/json")
+	checkStatus(t, response, http.StatusPreconditionFailed)
+	decodeResponse(t, response, &errorResponse)
+	if errorResponse.Code != "PRECONDITION_FAILED" || errorResponse.Current.Serial != 2020053001 ||
+		len(errorResponse.Current.Records) != 1 || errorResponse.Current.Records[0].Value != "192.0.2.1" {
+		t.Errorf("Unexpected error response %+v", errorResponse)
+	}
+
+	response = request(handler, "POST", "/zone-update/present",
+		`{"fqdn": "test", "rrtype": "A", "value": "192.0.2.2", "expect_value": "192.0.2.1", "if_serial": 2020053001}`)
+	checkResponse(t, response, http.StatusOK, "OK\n")
+}
+
+func TestRestApi_PresentRaw(t *testing.T) {
+	handler, zoneFile := newTestApi(t, config.Config{})
+	defer removeZoneFile(zoneFile)
+
+	response := request(handler, "POST", "/zone-update/delegations",
+		`{"fqdns": ["_acme-challenge.example.com."], "append": true}`)
+	checkStatus(t, response, http.StatusOK)
+
+	// Lego RAW mode, with the value computed from keyAuth
+	response = request(handler, "POST", "/zone-update/present",
+		`{"domain": "example.com", "token": "token", "keyAuth": "token.thumbprint"}`)
+	checkResponse(t, response, http.StatusOK, "OK\n")
+
+	var zone updater.Zone
+	response = request(handler, "GET", "/zone-update/records/_acme-challenge.example.com./TXT", "")
+	checkStatus(t, response, http.StatusOK)
+	decodeResponse(t, response, &zone)
+
+	if len(zone.Records) != 1 || zone.Records[0].Value != "61rBZ_4knHblO0MNoxFsXZ_eTFUHum0B6IVRbhvUn5I" ||
+		!zone.Records[0].Enabled {
+		t.Errorf("Unexpected records after RAW present %+v", zone.Records)
+	}
+
+	response = request(handler, "POST", "/zone-update/cleanup", `{"domain": "example.com", "token": "token"}`)
+	checkStatus(t, response, http.StatusBadRequest)
+
+	// The key authorization must be for the token
+	response = request(handler, "POST", "/zone-update/cleanup",
+		`{"domain": "example.com", "token": "other", "keyAuth": "token.thumbprint"}`)
+	checkStatus(t, response, http.StatusBadRequest)
+}
+
+func TestRestApi_Records(t *testing.T) {
+	handler, zoneFile := newTestApi(t, config.Config{})
+	defer removeZoneFile(zoneFile)
+
+	var zone updater.Zone
+	response := request(handler, "GET", "/zone-update/records/test/A", "")
+	checkStatus(t, response, http.StatusOK)
+	decodeResponse(t, response, &zone)
+
+	if zone.Serial != 2020053001 || len(zone.Records) != 1 || zone.Records[0].Value != "192.0.2.1" {
+		t.Errorf("Unexpected lookup result %+v", zone)
+	}
+
+	response = request(handler, "GET", "/zone-update/records/nosuchname/A", "")
+	checkStatus(t, response, http.StatusNotFound)
+}
+
+func newTestApi(t *testing.T, conf config.Config) (http.Handler, string) {
+	api, zoneFile := newTestRestApi(t, conf, "testdata/test.zone")
+
+	handler, err := api.Handler()
+	if err != nil {
+		removeZoneFile(zoneFile)
+		t.Fatalf("Unable to create API handler: %s", err)
+	}
+
+	return handler, zoneFile
+}
+
+// newTestRestApi creates an API for a temporary copy of the zone file source.
+func newTestRestApi(t *testing.T, conf config.Config, source string) (*restapi.RestApi, string) {
+	zoneFile := fmt.Sprintf("%s%c%d.%s.zone", os.TempDir(), os.PathSeparator, os.Getpid(), t.Name())
+	err := copyFile(source, zoneFile)
+	if err != nil {
+		t.Fatalf("Unable to create temporary zone file %s: %s", zoneFile, err)
+	}
+
+	conf.ZoneFileName = zoneFile
+	conf.UrlPrefix = "/zone-update"
+	conf.SequentialSerial = true
+	conf.HttpTimeoutSecs = 10
+
+	api := restapi.New(conf, updater.New(conf))
+	return &api, zoneFile
+}
+
+func removeZoneFile(zoneFile string) {
+	_ = os.Remove(zoneFile)
+	_ = os.Remove(zoneFile + ".lock")
+	_ = os.RemoveAll(zoneFile + ".idempotency")
+}
+
+func request(handler http.Handler, method string, target string, body string, headers ...string) *httptest.ResponseRecorder {
+	var bodyReader io.Reader
+	if body != "" {
+		bodyReader = strings.NewReader(body)
+	}
+
+	r := httptest.NewRequest(method, target, bodyReader)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	return w
+}
+
+func checkStatus(t *testing.T, response *httptest.ResponseRecorder, expectedStatus int) {
+	t.Helper()
+
+	if response.Code != expectedStatus {
+		t.Errorf("Expected status %d but got %d: %s", expectedStatus, response.Code, response.Body.String())
+	}
+}
+
+func checkResponse(t *testing.T, response *httptest.ResponseRecorder, expectedStatus int, expectedBody string) {
+	t.Helper()
+
+	checkStatus(t, response, expectedStatus)
+	if response.Body.String() != expectedBody {
+		t.Errorf("Expected response '%s' but got '%s'", expectedBody, response.Body.String())
+	}
+}
+
+func decodeResponse(t *testing.T, response *httptest.ResponseRecorder, value interface{}) {
+	t.Helper()
+
+	err := json.NewDecoder(response.Body).Decode(value)
+	if err != nil {
+		t.Fatalf("Unable to decode response: %s", err)
+	}
+}
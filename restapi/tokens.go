@@ -0,0 +1,136 @@
+package restapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi"
+	"net/http"
+	"strings"
+	"time"
+	"zoneupdated/authz"
+	"zoneupdated/httperror"
+	"zoneupdated/logging"
+	"zoneupdated/tokens"
+)
+
+type tokenIssueRequest struct {
+	tokens.Token
+	// ExpiresIn is the lifetime of the token in seconds, instead of Expires
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+type tokenIssueResponse struct {
+	tokens.Token
+	Secret string `json:"token"`
+}
+
+// tokenAuth authenticates requests with an Authorization: Bearer token.
+// Requests without one are passed to fallback, if there is one, and are
+// otherwise refused.
+func (api *RestApi) tokenAuth(fallback func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		var unauthenticated http.Handler
+		if fallback != nil {
+			unauthenticated = fallback(next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+				if unauthenticated != nil {
+					unauthenticated.ServeHTTP(w, r)
+				} else {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="`+api.conf.HttpAuthRealm+`"`)
+					writeError(w, r, httperror.Error(http.StatusUnauthorized, errors.New("Unauthorized")))
+				}
+				return
+			}
+
+			token, err := api.tokens.Check(strings.TrimSpace(header[7:]), clientIP(r))
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("Bearer token refused", "error", err)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+api.conf.HttpAuthRealm+`", error="invalid_token"`)
+				writeError(w, r, httperror.Error(http.StatusUnauthorized, errors.New("Unauthorized")))
+				return
+			}
+
+			// Already checked when the token was issued
+			policy, _ := token.Policy()
+			ctx := authz.NewContext(r.Context(), authz.Principal{Name: "token:" + token.ID, Policy: policy})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// requireAdmin only lets users allowed the admin operation through.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := authz.Authorize(r.Context(), authz.Admin, "", ""); err != nil {
+			writeJSONError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminTokenRoutes adds the endpoints for issuing and revoking tokens.
+func (api *RestApi) adminTokenRoutes(r chi.Router) {
+	r.Get("/", api.listTokens)
+	r.Post("/", api.issueToken)
+	r.Delete("/{id}", api.revokeToken)
+}
+
+func (api *RestApi) listTokens(w http.ResponseWriter, _ *http.Request) {
+	list, err := api.tokens.List()
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (api *RestApi) issueToken(w http.ResponseWriter, r *http.Request) {
+	var request tokenIssueRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSONError(w, badRequest("JSON Parse error: %s", err))
+		return
+	}
+
+	if request.ExpiresIn > 0 {
+		request.Expires = time.Now().Add(time.Duration(request.ExpiresIn) * time.Second)
+	}
+	if err := request.Validate(); err != nil {
+		writeJSONError(w, badRequest("%s", err))
+		return
+	}
+
+	token, secret, err := api.tokens.Issue(r.Context(), request.Token)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Issued token", "token", token.ID)
+
+	writeJSON(w, http.StatusCreated, tokenIssueResponse{Token: token, Secret: secret})
+}
+
+func (api *RestApi) revokeToken(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	err := api.tokens.Revoke(r.Context(), id)
+	if errors.Is(err, tokens.ErrNotFound) {
+		writeJSONError(w, httperror.Error(http.StatusNotFound, fmt.Errorf("no token %s", id)))
+		return
+	} else if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Revoked token", "token", id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
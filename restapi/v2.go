@@ -0,0 +1,272 @@
+package restapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"zoneupdated/httperror"
+	"zoneupdated/updater"
+)
+
+// rrset is the representation of an RRset in the v2 API.
+type rrset struct {
+	Zone    string        `json:"zone"`
+	Name    string        `json:"name"`
+	Type    string        `json:"type"`
+	TTL     uint32        `json:"ttl,omitempty"`
+	Serial  uint32        `json:"serial"`
+	Records []rrsetRecord `json:"records"`
+}
+
+type rrsetRecord struct {
+	Value   string `json:"value"`
+	Enabled bool   `json:"enabled"`
+}
+
+type rrsetRecordRequest struct {
+	Value   string `json:"value"`
+	Enabled *bool  `json:"enabled"`
+}
+
+type rrsetPutRequest struct {
+	TTL     uint32               `json:"ttl"`
+	Records []rrsetRecordRequest `json:"records"`
+}
+
+type rrsetPatchRequest struct {
+	TTL     *uint32 `json:"ttl"`
+	Value   *string `json:"value"`
+	Enabled *bool   `json:"enabled"`
+}
+
+// v2Routes adds the resource oriented v2 API.
+func (api *RestApi) v2Routes(r chi.Router) {
+	r.Get("/zones/{zone}/rrsets/{name}/{type}", api.getRRset)
+	r.Put("/zones/{zone}/rrsets/{name}/{type}", api.putRRset)
+	r.Patch("/zones/{zone}/rrsets/{name}/{type}", api.patchRRset)
+	r.Delete("/zones/{zone}/rrsets/{name}/{type}", api.deleteRRset)
+}
+
+func (api *RestApi) getRRset(w http.ResponseWriter, r *http.Request) {
+	zone, err := api.updater.Zone(r.Context())
+	if err == nil {
+		err = checkRRsetName(r, zone)
+	}
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	current := newRRset(zone, chi.URLParam(r, "name"), chi.URLParam(r, "type"))
+	if len(current.Records) == 0 {
+		writeJSONError(w, httperror.Error(http.StatusNotFound, updater.ErrNotFound))
+		return
+	}
+
+	writeRRset(w, http.StatusOK, current)
+}
+
+func (api *RestApi) putRRset(w http.ResponseWriter, r *http.Request) {
+	var request rrsetPutRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSONError(w, badRequest("JSON Parse error: %s", err))
+		return
+	}
+
+	records := make([]updater.Record, 0, len(request.Records))
+	for _, record := range request.Records {
+		enabled := record.Enabled == nil || *record.Enabled
+		records = append(records, updater.Record{TTL: request.TTL, Value: record.Value, Enabled: enabled})
+	}
+
+	api.editRRset(w, r, func(zone updater.Zone, current []updater.Record) ([]updater.Record, error) {
+		return records, nil
+	})
+}
+
+// patchRRset changes the TTL, value or whether they are enabled of all of the
+// records in an existing RRset.
+func (api *RestApi) patchRRset(w http.ResponseWriter, r *http.Request) {
+	var request rrsetPatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSONError(w, badRequest("JSON Parse error: %s", err))
+		return
+	}
+
+	api.editRRset(w, r, func(zone updater.Zone, current []updater.Record) ([]updater.Record, error) {
+		if len(current) == 0 {
+			return nil, httperror.Error(http.StatusNotFound, updater.ErrNotFound)
+		}
+
+		records := make([]updater.Record, 0, len(current))
+		for _, record := range current {
+			if request.TTL != nil {
+				record.TTL = *request.TTL
+			}
+			if request.Value != nil {
+				record.Value = *request.Value
+			}
+			if request.Enabled != nil {
+				record.Enabled = *request.Enabled
+			}
+			records = append(records, record)
+		}
+
+		return records, nil
+	})
+}
+
+func (api *RestApi) deleteRRset(w http.ResponseWriter, r *http.Request) {
+	api.editRRset(w, r, func(zone updater.Zone, current []updater.Record) ([]updater.Record, error) {
+		if len(current) == 0 {
+			return nil, httperror.Error(http.StatusNotFound, updater.ErrNotFound)
+		}
+
+		return nil, nil
+	})
+}
+
+// editRRset makes an edit after checking the request, including any If-Match
+// precondition, while the zone is locked. It responds with the new RRset, as
+// it was written.
+func (api *RestApi) editRRset(w http.ResponseWriter, r *http.Request, edit updater.RRsetEdit) {
+	name := chi.URLParam(r, "name")
+	rrtype := strings.ToUpper(chi.URLParam(r, "type"))
+
+	if rrtype == "SOA" {
+		writeJSONError(w, badRequest("SOA records can't be changed"))
+		return
+	}
+
+	var failed *rrset
+	_, zone, err := api.updater.EditRRset(r.Context(), name, rrtype,
+		func(zone updater.Zone, current []updater.Record) ([]updater.Record, error) {
+			if err := checkRRsetName(r, zone); err != nil {
+				return nil, err
+			}
+
+			if !ifMatch(r, zone.Serial, len(current) > 0) {
+				state := newRRset(zone, name, rrtype)
+				failed = &state
+				return nil, httperror.Error(http.StatusPreconditionFailed, errors.New("precondition failed"))
+			}
+
+			records, err := edit(zone, current)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, record := range records {
+				if err := checkValue(record.Value); err != nil {
+					return nil, httperror.WithCode(http.StatusBadRequest, httperror.InvalidValue, err)
+				}
+			}
+
+			return records, nil
+		})
+
+	if failed != nil {
+		w.Header().Set("ETag", etag(failed.Serial))
+		response := newErrorResponse(err)
+		response.Current = failed
+		writeJSON(w, http.StatusPreconditionFailed, response)
+		return
+	} else if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+
+	// The response describes the zone as the edit left it, rather than as it
+	// may be by now
+	set := newRRset(zone, name, rrtype)
+	if len(set.Records) == 0 {
+		w.Header().Set("ETag", etag(set.Serial))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeRRset(w, http.StatusOK, set)
+}
+
+// checkRRsetName checks that the request is for this zone, and a name in it.
+func checkRRsetName(r *http.Request, zone updater.Zone) error {
+	zoneName := chi.URLParam(r, "zone")
+	if !strings.EqualFold(strings.TrimSuffix(zoneName, ".")+".", zone.Name) {
+		return httperror.WithCode(http.StatusNotFound, httperror.ZoneNotFound, fmt.Errorf("no such zone %s", zoneName))
+	}
+
+	name := chi.URLParam(r, "name")
+	if fqdn := zone.FQDN(name); zone.RelativeName(fqdn) == fqdn {
+		return httperror.WithCode(http.StatusForbidden, httperror.ForbiddenName,
+			fmt.Errorf("%s is not in zone %s", name, zone.Name))
+	}
+
+	return nil
+}
+
+// checkValue rejects values that can't be written to the zone file as a
+// single line. Anything else that could break it, such as ; or (, is quoted.
+func checkValue(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("value not provided")
+	}
+
+	for _, c := range value {
+		if unicode.IsControl(c) && c != '\t' {
+			return fmt.Errorf("value contains control character %q", c)
+		}
+	}
+
+	return nil
+}
+
+func newRRset(zone updater.Zone, name string, rrtype string) rrset {
+	set := rrset{
+		Zone:    zone.Name,
+		Name:    zone.FQDN(name),
+		Type:    strings.ToUpper(rrtype),
+		Serial:  zone.Serial,
+		Records: []rrsetRecord{},
+	}
+
+	for _, record := range zone.RRset(name, rrtype) {
+		if set.TTL == 0 {
+			set.TTL = record.TTL
+		}
+		set.Records = append(set.Records, rrsetRecord{Value: record.Value, Enabled: record.Enabled})
+	}
+
+	return set
+}
+
+func writeRRset(w http.ResponseWriter, status int, set rrset) {
+	w.Header().Set("ETag", etag(set.Serial))
+	writeJSON(w, status, set)
+}
+
+// etag is the entity tag for anything in the zone, which changes with its serial.
+func etag(serial uint32) string {
+	return strconv.Quote(strconv.FormatUint(uint64(serial), 10))
+}
+
+// ifMatch checks the request's If-Match header, if any, against the zone's serial.
+func ifMatch(r *http.Request, serial uint32, exists bool) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" && exists || tag == etag(serial) {
+			return true
+		}
+	}
+
+	return false
+}
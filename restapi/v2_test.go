@@ -0,0 +1,129 @@
+package restapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"zoneupdated/config"
+)
+
+type testRRset struct {
+	Zone    string `json:"zone"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	TTL     uint32 `json:"ttl"`
+	Serial  uint32 `json:"serial"`
+	Records []struct {
+		Value   string `json:"value"`
+		Enabled bool   `json:"enabled"`
+	} `json:"records"`
+}
+
+func TestV2_RRsets(t *testing.T) {
+	handler, zoneFile := newTestApi(t, config.Config{ZoneName: "dyn.example.com"})
+	defer removeZoneFile(zoneFile)
+
+	const path = "/zone-update/v2/zones/dyn.example.com/rrsets/test/A"
+
+	var set testRRset
+	response := request(handler, "GET", path, "")
+	checkStatus(t, response, http.StatusOK)
+	decodeResponse(t, response, &set)
+	if set.Name != "test.dyn.example.com." || set.TTL != 60 || set.Serial != 2020053001 || len(set.Records) != 1 ||
+		set.Records[0].Value != "192.0.2.1" || !set.Records[0].Enabled {
+		t.Errorf("Unexpected RRset %+v", set)
+	}
+	if etag := response.Header().Get("ETag"); etag != `"2020053001"` {
+		t.Errorf("Unexpected ETag %s", etag)
+	}
+
+	response = request(handler, "PUT", path, `{"ttl": 300, "records": [{"value": "192.0.2.7"}, {"value": "192.0.2.8", "enabled": false}]}`,
+		"If-Match", `"2020053001"`)
+	checkStatus(t, response, http.StatusOK)
+	decodeResponse(t, response, &set)
+	if set.TTL != 300 || set.Serial != 2020053002 || len(set.Records) != 2 || set.Records[1].Enabled {
+		t.Errorf("Unexpected RRset after PUT %+v", set)
+	}
+
+	// The serial has changed, so the old ETag no longer matches
+	response = request(handler, "PATCH", path, `{"enabled": true}`, "If-Match", `"2020053001"`)
+	checkStatus(t, response, http.StatusPreconditionFailed)
+	var failed struct {
+		Error   string    `json:"error"`
+		Current testRRset `json:"current"`
+	}
+	decodeResponse(t, response, &failed)
+	if failed.Current.Serial != 2020053002 || len(failed.Current.Records) != 2 {
+		t.Errorf("Unexpected precondition failure %+v", failed)
+	}
+
+	response = request(handler, "PATCH", path, `{"enabled": true}`, "If-Match", response.Header().Get("ETag"))
+	checkStatus(t, response, http.StatusOK)
+	decodeResponse(t, response, &set)
+	if len(set.Records) != 2 || !set.Records[1].Enabled {
+		t.Errorf("Unexpected RRset after PATCH %+v", set)
+	}
+
+	response = request(handler, "PUT", path, `{"records": [{"value": "192.0.2.7\ntest2 IN A 192.0.2.66"}]}`)
+	checkStatus(t, response, http.StatusBadRequest)
+
+	response = request(handler, "DELETE", path, "", "If-Match", "*")
+	checkStatus(t, response, http.StatusNoContent)
+
+	response = request(handler, "GET", path, "")
+	checkStatus(t, response, http.StatusNotFound)
+
+	response = request(handler, "DELETE", path, "")
+	checkStatus(t, response, http.StatusNotFound)
+
+	response = request(handler, "GET", "/zone-update/v2/zones/example.org/rrsets/test/A", "")
+	checkStatus(t, response, http.StatusNotFound)
+
+	response = request(handler, "GET", "/zone-update/v2/zones/dyn.example.com/rrsets/test.example.org./A", "")
+	checkStatus(t, response, http.StatusForbidden)
+	var errorResponse struct {
+		Code string `json:"code"`
+	}
+	decodeResponse(t, response, &errorResponse)
+	if errorResponse.Code != "FORBIDDEN_NAME" {
+		t.Errorf("Expected code FORBIDDEN_NAME but got %s", errorResponse.Code)
+	}
+
+	response = request(handler, "PUT", "/zone-update/v2/zones/dyn.example.com/rrsets/new.dyn.example.com./TXT",
+		`{"records": [{"value": "hello world"}]}`)
+	checkStatus(t, response, http.StatusOK)
+	decodeResponse(t, response, &set)
+	if set.Name != "new.dyn.example.com." || len(set.Records) != 1 || set.Records[0].Value != "hello world" {
+		t.Errorf("Unexpected new RRset %+v", set)
+	}
+
+	if zone := readFixture(t, zoneFile); !strings.HasSuffix(zone, "new\tIN TXT\t\"hello world\"\n") {
+		t.Errorf("Unexpected zone file:\n%s", zone)
+	}
+}
+
+func TestV2_OpenApi(t *testing.T) {
+	handler, zoneFile := newTestApi(t, config.Config{User: "user", Password: "password"})
+	defer removeZoneFile(zoneFile)
+
+	response := request(handler, "GET", "/zone-update/v2/openapi.json", "")
+	checkStatus(t, response, http.StatusOK)
+
+	var document struct {
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+		Paths map[string]interface{} `json:"paths"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&document); err != nil {
+		t.Fatalf("OpenAPI document is not valid JSON: %s", err)
+	}
+	if len(document.Servers) != 1 || document.Servers[0].URL != "/zone-update/v2" || len(document.Paths) == 0 {
+		t.Errorf("Unexpected OpenAPI document %+v", document)
+	}
+
+	// The API itself still requires authentication
+	response = request(handler, "GET", "/zone-update/v2/zones/dyn.example.com/rrsets/test/A", "")
+	checkStatus(t, response, http.StatusUnauthorized)
+}
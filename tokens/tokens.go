@@ -0,0 +1,342 @@
+package tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofrs/flock"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"zoneupdated/atomicfile"
+	"zoneupdated/authz"
+)
+
+// Tokens look like zu_<id>_<secret>, so that they can be recognized, and
+// found by id without comparing secrets against every token.
+const (
+	tokenPrefix = "zu_"
+	idBytes     = 8
+	secretBytes = 32
+)
+
+// ErrNotFound is returned when revoking a token that doesn't exist.
+var ErrNotFound = errors.New("no such token")
+
+// Token is an API token. Only a hash of its secret is kept.
+type Token struct {
+	ID          string            `json:"id"`
+	Hash        string            `json:"hash,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Allow       []string          `json:"allow,omitempty"`
+	Deny        []string          `json:"deny,omitempty"`
+	Types       []string          `json:"types,omitempty"`
+	Ops         []authz.Operation `json:"ops,omitempty"`
+	AllowFrom   []string          `json:"allowfrom,omitempty"`
+	Created     time.Time         `json:"created"`
+	Expires     time.Time         `json:"expires"`
+}
+
+// Store keeps tokens in a JSON file. Tokens may be issued and revoked by other
+// processes, such as the token subcommand, so the file is locked while it is
+// changed, and read again whenever it has changed.
+type Store struct {
+	filename string
+	mutex    sync.RWMutex
+	tokens   map[string]Token
+	modTime  time.Time
+	size     int64
+}
+
+func New(filename string) (*Store, error) {
+	store := Store{filename: filename, tokens: make(map[string]Token)}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+
+	return &store, nil
+}
+
+// Reload reads the token file if it has changed since it was last read.
+func (store *Store) Reload() error {
+	info, err := os.Stat(store.filename)
+	if os.IsNotExist(err) {
+		store.mutex.Lock()
+		store.tokens, store.modTime, store.size = make(map[string]Token), time.Time{}, 0
+		store.mutex.Unlock()
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	store.mutex.RLock()
+	unchanged := info.ModTime().Equal(store.modTime) && info.Size() == store.size
+	store.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	tokens, err := store.read()
+	if err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	store.tokens, store.modTime, store.size = tokens, info.ModTime(), info.Size()
+	store.mutex.Unlock()
+
+	return nil
+}
+
+func (store *Store) read() (map[string]Token, error) {
+	tokens := make(map[string]Token)
+
+	file, err := os.Open(store.filename)
+	if os.IsNotExist(err) {
+		return tokens, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var list []Token
+	if err = json.NewDecoder(file).Decode(&list); err != nil {
+		return nil, fmt.Errorf("while parsing %s: %s", store.filename, err)
+	}
+
+	for _, token := range list {
+		tokens[token.ID] = token
+	}
+
+	return tokens, nil
+}
+
+// Check returns the token for secret if it is valid, hasn't expired and may be
+// used from ip.
+func (store *Store) Check(secret string, ip net.IP) (Token, error) {
+	if err := store.Reload(); err != nil {
+		return Token{}, err
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(secret, tokenPrefix), "_", 2)
+	if !strings.HasPrefix(secret, tokenPrefix) || len(parts) != 2 {
+		return Token{}, errors.New("malformed token")
+	}
+
+	store.mutex.RLock()
+	token, ok := store.tokens[parts[0]]
+	store.mutex.RUnlock()
+
+	if !ok || subtle.ConstantTimeCompare([]byte(hashSecret(parts[1])), []byte(token.Hash)) != 1 {
+		return Token{}, errors.New("invalid token")
+	}
+
+	if !time.Now().Before(token.Expires) {
+		return Token{}, fmt.Errorf("token %s has expired", token.ID)
+	}
+
+	if !token.AllowedFrom(ip) {
+		return Token{}, fmt.Errorf("token %s may not be used from %s", token.ID, ip)
+	}
+
+	return token, nil
+}
+
+// List returns the tokens, without their hashes, in the order they were issued.
+func (store *Store) List() ([]Token, error) {
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+
+	store.mutex.RLock()
+	list := make([]Token, 0, len(store.tokens))
+	for _, token := range store.tokens {
+		token.Hash = ""
+		list = append(list, token)
+	}
+	store.mutex.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created) ||
+			list[i].Created.Equal(list[j].Created) && list[i].ID < list[j].ID
+	})
+
+	return list, nil
+}
+
+// Issue saves a new token with the scope, expiry and restrictions of token,
+// and returns it with the secret to give to the client, which isn't kept.
+func (store *Store) Issue(ctx context.Context, token Token) (Token, string, error) {
+	if err := token.Validate(); err != nil {
+		return Token{}, "", err
+	}
+
+	id, err := randomHex(idBytes)
+	if err != nil {
+		return Token{}, "", err
+	}
+	secret, err := randomHex(secretBytes)
+	if err != nil {
+		return Token{}, "", err
+	}
+
+	token.ID = id
+	token.Hash = hashSecret(secret)
+	token.Created = time.Now().UTC().Truncate(time.Second)
+	token.Expires = token.Expires.UTC().Truncate(time.Second)
+
+	err = store.update(ctx, func(tokens map[string]Token) error {
+		tokens[token.ID] = token
+		return nil
+	})
+	if err != nil {
+		return Token{}, "", err
+	}
+
+	token.Hash = ""
+	return token, tokenPrefix + id + "_" + secret, nil
+}
+
+// Revoke removes the token with id.
+func (store *Store) Revoke(ctx context.Context, id string) error {
+	return store.update(ctx, func(tokens map[string]Token) error {
+		if _, ok := tokens[id]; !ok {
+			return ErrNotFound
+		}
+
+		delete(tokens, id)
+		return nil
+	})
+}
+
+// update changes the tokens in the file while holding its lock, starting from
+// what is in the file, in case another process has changed it.
+func (store *Store) update(ctx context.Context, change func(tokens map[string]Token) error) error {
+	lockfile := flock.New(store.filename + ".lock")
+	locked, err := lockfile.TryLockContext(ctx, 100*time.Millisecond)
+	if !locked {
+		if err == nil {
+			err = errors.New("unknown error")
+		}
+		return fmt.Errorf("unable to lock %s: %s", store.filename, err)
+	}
+	defer lockfile.Unlock()
+
+	tokens, err := store.read()
+	if err != nil {
+		return err
+	}
+
+	if err = change(tokens); err != nil {
+		return err
+	}
+
+	list := make([]Token, 0, len(tokens))
+	for _, token := range tokens {
+		list = append(list, token)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	file, err := atomicfile.Open(store.filename)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(list); err != nil {
+		_ = file.Abort()
+		return err
+	}
+
+	if err = file.Commit(); err != nil {
+		return err
+	}
+
+	// Read it back, so that the modification time is the one just written
+	store.mutex.Lock()
+	store.modTime = time.Time{}
+	store.mutex.Unlock()
+
+	return store.Reload()
+}
+
+// Validate checks the token's scope, expiry and restrictions.
+func (token Token) Validate() error {
+	if !token.Expires.After(time.Now()) {
+		return errors.New("tokens must expire, in the future")
+	}
+
+	if _, err := token.Policy(); err != nil {
+		return err
+	}
+
+	for _, cidr := range token.AllowFrom {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid CIDR %s", cidr)
+		}
+	}
+
+	return nil
+}
+
+// Policy is the authorization policy for the token's scope.
+func (token Token) Policy() (*authz.Policy, error) {
+	var fields []string
+	add := func(key string, values []string) {
+		if len(values) > 0 {
+			fields = append(fields, key+"="+strings.Join(values, ","))
+		}
+	}
+
+	ops := make([]string, 0, len(token.Ops))
+	for _, op := range token.Ops {
+		ops = append(ops, string(op))
+	}
+
+	add("allow", token.Allow)
+	add("deny", token.Deny)
+	add("types", token.Types)
+	add("ops", ops)
+
+	return authz.ParsePolicy(fields)
+}
+
+// AllowedFrom checks whether the token may be used from ip.
+func (token Token) AllowedFrom(ip net.IP) bool {
+	if len(token.AllowFrom) == 0 {
+		return true
+	}
+
+	for _, cidr := range token.AllowFrom {
+		_, network, err := net.ParseCIDR(cidr)
+		if err == nil && ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Secrets are random, so a fast hash is enough to keep them safe
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(numBytes int) (string, error) {
+	b := make([]byte, numBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
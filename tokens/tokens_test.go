@@ -0,0 +1,105 @@
+package tokens_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+	"zoneupdated/authz"
+	"zoneupdated/tokens"
+)
+
+func TestStore(t *testing.T) {
+	filename := fmt.Sprintf("%s%c%d.%s.tokens", os.TempDir(), os.PathSeparator, os.Getpid(), t.Name())
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".lock")
+
+	store, err := tokens.New(filename)
+	if err != nil {
+		t.Fatalf("Unable to create token store: %s", err)
+	}
+
+	ctx := context.Background()
+	ip := net.ParseIP("192.0.2.1")
+
+	token, secret, err := store.Issue(ctx, tokens.Token{Allow: []string{".example.com"}, Ops: []authz.Operation{authz.Present},
+		AllowFrom: []string{"192.0.2.0/24"}, Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Unable to issue token: %s", err)
+	}
+	if token.Hash != "" || token.ID == "" {
+		t.Errorf("Unexpected token %+v", token)
+	}
+
+	checked, err := store.Check(secret, ip)
+	if err != nil || checked.ID != token.ID {
+		t.Errorf("Expected token to be valid but got %v", err)
+	}
+
+	policy, err := checked.Policy()
+	if err != nil || policy.Authorize(authz.Present, "www.example.com", "A") != nil ||
+		policy.Authorize(authz.Cleanup, "www.example.com", "A") == nil {
+		t.Errorf("Unexpected policy %+v, %v", policy, err)
+	}
+
+	if _, err = store.Check(secret, net.ParseIP("198.51.100.1")); err == nil {
+		t.Error("Expected token not to be usable from outside its CIDR")
+	}
+	if _, err = store.Check(secret+"0", ip); err == nil {
+		t.Error("Expected wrong secret to be refused")
+	}
+	if _, err = store.Check("Basic "+secret, ip); err == nil {
+		t.Error("Expected malformed token to be refused")
+	}
+
+	// Another store using the same file, as the token subcommand would
+	other, err := tokens.New(filename)
+	if err != nil {
+		t.Fatalf("Unable to create token store: %s", err)
+	}
+
+	expired, expiredSecret, err := other.Issue(ctx, tokens.Token{Expires: time.Now().Add(time.Second)})
+	if err != nil {
+		t.Fatalf("Unable to issue token: %s", err)
+	}
+	if err = other.Revoke(ctx, token.ID); err != nil {
+		t.Fatalf("Unable to revoke token: %s", err)
+	}
+
+	if _, err = store.Check(secret, ip); err == nil {
+		t.Error("Expected revoked token to be refused")
+	}
+	if _, err = store.Check(expiredSecret, ip); err != nil {
+		t.Errorf("Expected token to be valid until it expires but got %s", err)
+	}
+
+	list, err := store.List()
+	if err != nil || len(list) != 1 || list[0].ID != expired.ID || list[0].Hash != "" {
+		t.Errorf("Unexpected tokens %+v, %v", list, err)
+	}
+
+	if err = store.Revoke(ctx, token.ID); !errors.Is(err, tokens.ErrNotFound) {
+		t.Errorf("Expected revoking again to fail with ErrNotFound but got %v", err)
+	}
+
+	time.Sleep(time.Until(expired.Expires.Add(time.Second)))
+	if _, err = store.Check(expiredSecret, ip); err == nil {
+		t.Error("Expected expired token to be refused")
+	}
+}
+
+func TestToken_Validate(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	for _, token := range []tokens.Token{
+		{},
+		{Expires: expires, Ops: []authz.Operation{"destroy"}},
+		{Expires: expires, AllowFrom: []string{"192.0.2.1"}},
+	} {
+		if err := token.Validate(); err == nil {
+			t.Errorf("Expected token %+v to be invalid", token)
+		}
+	}
+}
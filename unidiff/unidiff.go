@@ -0,0 +1,182 @@
+package unidiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ContextLines = 3
+)
+
+type opKind byte
+
+const (
+	opEqual  opKind = ' '
+	opDelete opKind = '-'
+	opInsert opKind = '+'
+)
+
+type op struct {
+	kind opKind
+	line string
+	// 0-based line numbers in from and to that this op is positioned at
+	fromLine int
+	toLine   int
+}
+
+// Lines splits text into lines the way Unified expects, without a trailing empty line.
+func Lines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Unified returns a unified diff between from and to, or an empty string if they are identical.
+func Unified(fromName string, toName string, from []string, to []string) string {
+	ops := diff(from, to)
+
+	var sb strings.Builder
+	for start := 0; start < len(ops); {
+		// find the next change
+		for start < len(ops) && ops[start].kind == opEqual {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		hunkStart := start - ContextLines
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+
+		// extend the hunk until there are more than 2*ContextLines unchanged lines in a row
+		end := start
+		for end < len(ops) {
+			if ops[end].kind != opEqual {
+				end++
+				continue
+			}
+
+			run := end
+			for run < len(ops) && ops[run].kind == opEqual {
+				run++
+			}
+			if run == len(ops) || run-end > 2*ContextLines {
+				end += ContextLines
+				if end > len(ops) {
+					end = len(ops)
+				}
+				break
+			}
+			end = run
+		}
+
+		if sb.Len() == 0 {
+			_, _ = fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		writeHunk(&sb, ops[hunkStart:end])
+
+		start = end
+	}
+
+	return sb.String()
+}
+
+func writeHunk(sb *strings.Builder, ops []op) {
+	fromCount, toCount := 0, 0
+	for _, o := range ops {
+		if o.kind != opInsert {
+			fromCount++
+		}
+		if o.kind != opDelete {
+			toCount++
+		}
+	}
+
+	_, _ = fmt.Fprintf(sb, "@@ -%s +%s @@\n",
+		hunkRange(ops[0].fromLine, fromCount), hunkRange(ops[0].toLine, toCount))
+
+	for _, o := range ops {
+		sb.WriteByte(byte(o.kind))
+		sb.WriteString(o.line)
+		sb.WriteByte('\n')
+	}
+}
+
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		// an empty range refers to the line before it
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// diff computes an edit script using the longest common subsequence of the
+// lines that differ. Zone files are small and updates usually touch only a
+// few lines, so the common prefix and suffix are trimmed first.
+func diff(from []string, to []string) []op {
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix &&
+		from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+
+	a := from[prefix : len(from)-suffix]
+	b := to[prefix : len(to)-suffix]
+
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := make([]op, 0, len(from)+len(b))
+	for k := 0; k < prefix; k++ {
+		ops = append(ops, op{opEqual, from[k], k, k})
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, op{opEqual, a[i], prefix + i, prefix + j})
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			ops = append(ops, op{opInsert, b[j], prefix + i, prefix + j})
+			j++
+		default:
+			ops = append(ops, op{opDelete, a[i], prefix + i, prefix + j})
+			i++
+		}
+	}
+
+	for k := 0; k < suffix; k++ {
+		ops = append(ops, op{opEqual, from[len(from)-suffix+k], len(from) - suffix + k, len(to) - suffix + k})
+	}
+
+	return ops
+}
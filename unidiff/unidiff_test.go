@@ -0,0 +1,57 @@
+package unidiff_test
+
+import (
+	"testing"
+	"zoneupdated/unidiff"
+)
+
+const (
+	original = "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven\ntwelve\n"
+)
+
+func TestUnified_Identical(t *testing.T) {
+	lines := unidiff.Lines(original)
+	diff := unidiff.Unified("a", "b", lines, lines)
+	if diff != "" {
+		t.Errorf("Expected no diff for identical input, got '%s'", diff)
+	}
+}
+
+func TestUnified_Change(t *testing.T) {
+	changed := "one\ntwo\nthree\nfour\nfive\nSIX\nseven\neight\nnine\nten\neleven\ntwelve\n"
+
+	checkDiff(t, original, changed, "--- a\n+++ b\n"+
+		"@@ -3,7 +3,7 @@\n"+
+		" three\n four\n five\n-six\n+SIX\n seven\n eight\n nine\n")
+}
+
+func TestUnified_TwoHunks(t *testing.T) {
+	changed := "ONE\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven\nTWELVE\n"
+
+	checkDiff(t, original, changed, "--- a\n+++ b\n"+
+		"@@ -1,4 +1,4 @@\n"+
+		"-one\n+ONE\n two\n three\n four\n"+
+		"@@ -9,4 +9,4 @@\n"+
+		" nine\n ten\n eleven\n-twelve\n+TWELVE\n")
+}
+
+func TestUnified_InsertDelete(t *testing.T) {
+	checkDiff(t, "one\ntwo\n", "zero\none\ntwo\nthree\n", "--- a\n+++ b\n"+
+		"@@ -1,2 +1,4 @@\n"+
+		"+zero\n one\n two\n+three\n")
+
+	checkDiff(t, "one\ntwo\nthree\n", "one\nthree\n", "--- a\n+++ b\n"+
+		"@@ -1,3 +1,2 @@\n"+
+		" one\n-two\n three\n")
+
+	checkDiff(t, "", "one\n", "--- a\n+++ b\n"+
+		"@@ -0,0 +1 @@\n"+
+		"+one\n")
+}
+
+func checkDiff(t *testing.T, from string, to string, expected string) {
+	diff := unidiff.Unified("a", "b", unidiff.Lines(from), unidiff.Lines(to))
+	if diff != expected {
+		t.Errorf("Expected diff:\n%s\nbut got:\n%s", expected, diff)
+	}
+}
@@ -0,0 +1,182 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"zoneupdated/audit"
+	"zoneupdated/authz"
+	"zoneupdated/httperror"
+	"zoneupdated/logging"
+)
+
+// change is what an attempt made of the enabled records of a name and type,
+// for the audit log.
+type change struct {
+	name   string
+	rrtype string
+	op     authz.Operation
+	old    []string
+	new    []string
+}
+
+// SetAuditLog makes the updater write each change it attempts to auditLog.
+func (updater *Updater) SetAuditLog(auditLog *audit.Log) {
+	updater.auditLog = auditLog
+}
+
+// audit logs the changes attempted since started, with the outcome of result
+// and err, and writes them to the audit log if there is one.
+func (updater *Updater) audit(ctx context.Context, started time.Time, dryRun bool, changes []*change, result Result, err error) {
+	logger := logging.FromContext(ctx)
+	log := logger.Info
+	switch {
+	case err == nil && !result.Changed:
+		log = logger.Debug
+	case err != nil && httperror.StatusOf(err) >= http.StatusInternalServerError:
+		log = logger.Error
+	}
+
+	principal, _ := authz.FromContext(ctx)
+	request, _ := audit.FromContext(ctx)
+	duration := time.Since(started)
+
+	for _, c := range changes {
+		event := audit.Event{
+			Time:       started.UTC(),
+			RequestID:  request.ID,
+			Principal:  principal.Name,
+			ClientIP:   request.ClientIP,
+			Zone:       updater.zoneName(""),
+			Name:       c.name,
+			Type:       strings.ToUpper(c.rrtype),
+			Operation:  string(c.op),
+			Old:        nonNil(c.old),
+			New:        nonNil(c.new),
+			Serial:     result.Serial,
+			DryRun:     dryRun,
+			Outcome:    outcome(result, err),
+			DurationMs: float64(duration.Microseconds()) / 1000,
+		}
+		if err != nil {
+			event.Error = err.Error()
+		}
+
+		updatesTotal.WithLabelValues(event.Outcome).Inc()
+		log("Change", "name", event.Name, "type", event.Type, "operation", event.Operation, "outcome", event.Outcome,
+			"serial", event.Serial, "dry_run", dryRun, "error", event.Error)
+
+		if updater.auditLog == nil {
+			continue
+		}
+		if err := updater.auditLog.Write(event); err != nil {
+			logger.Error("Unable to write to the audit log", "error", err)
+		}
+	}
+}
+
+func outcome(result Result, err error) string {
+	switch {
+	case err == nil && result.Changed:
+		return audit.Changed
+	case err == nil:
+		return audit.Unchanged
+	case errors.Is(err, ErrNotFound):
+		return audit.NotFound
+	case httperror.StatusOf(err) == http.StatusForbidden:
+		return audit.Denied
+	default:
+		return audit.Failed
+	}
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+
+	return values
+}
+
+// batchChanges groups the records of a batch by name and type.
+func batchChanges(changes Changes) []*change {
+	var batch []*change
+	byKey := make(map[string]*change)
+	add := func(record Record) *change {
+		key := strings.ToLower(record.Name) + " " + strings.ToUpper(record.RRType)
+		c, ok := byKey[key]
+		if !ok {
+			c = &change{name: record.Name, rrtype: record.RRType}
+			byKey[key] = c
+			batch = append(batch, c)
+		}
+		return c
+	}
+
+	for _, record := range changes.Delete {
+		c := add(record)
+		c.old = append(c.old, record.Value)
+	}
+	for _, record := range changes.Create {
+		c := add(record)
+		c.new = append(c.new, record.Value)
+	}
+
+	for _, c := range batch {
+		switch {
+		case len(c.new) == 0:
+			c.op = authz.Cleanup
+		case len(c.old) == 0:
+			c.op = authz.Create
+		default:
+			c.op = authz.Present
+		}
+	}
+
+	return batch
+}
+
+// rrset replaces the values removed and added by a batch change with those of
+// the RRset in zone before and after the change.
+func (c *change) rrset(zone Zone) {
+	removed, added := c.old, c.new
+	c.old = enabledValues(zone.RRset(c.name, c.rrtype))
+	c.new = nil
+
+	for _, value := range c.old {
+		if i := indexOf(removed, value); i >= 0 {
+			removed = append(removed[:i:i], removed[i+1:]...)
+			continue
+		}
+		c.new = append(c.new, value)
+	}
+	for _, value := range added {
+		if indexOf(c.new, value) < 0 {
+			c.new = append(c.new, value)
+		}
+	}
+}
+
+func indexOf(values []string, value string) int {
+	for i := range values {
+		if values[i] == value {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// enabledValues returns the values of the enabled records.
+func enabledValues(records []Record) []string {
+	var values []string
+	for _, record := range records {
+		if record.Enabled {
+			values = append(values, record.Value)
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,69 @@
+package updater
+
+import (
+	"context"
+	"strings"
+	"time"
+	"zoneupdated/authz"
+)
+
+// authorize checks that the user in ctx may do op on a name given to Update,
+// Lookup or Delegations, qualified as by qualify, and that changes to the name
+// aren't rate limited. The zone file is only read if the user's policy
+// restricts names, or changes to names are limited.
+func (updater *Updater) authorize(ctx context.Context, op authz.Operation, name string, rrtype string) error {
+	if !authz.Restricted(ctx) && updater.names == nil {
+		return authz.Authorize(ctx, op, "", rrtype)
+	}
+
+	zone, err := updater.readZone(ctx)
+	if err != nil {
+		return err
+	}
+
+	fqdn := zone.qualify(name)
+	if err = authz.Authorize(ctx, op, fqdn, rrtype); err != nil {
+		return err
+	}
+
+	return updater.limit(op, fqdn)
+}
+
+// limit takes a token from the rate limit for changes to fqdn, if there is one.
+func (updater *Updater) limit(op authz.Operation, fqdn string) error {
+	if updater.names == nil || op == authz.Read || op == "" {
+		return nil
+	}
+
+	return updater.names.Allow(strings.ToLower(strings.TrimSuffix(fqdn, ".")), time.Now())
+}
+
+// qualify returns name fully qualified like FQDN, except that a name ending
+// with the zone's name is taken to be fully qualified already, as clients of
+// the original API often leave out the trailing dot.
+func (zone Zone) qualify(name string) string {
+	suffix := strings.ToLower(strings.TrimSuffix(zone.Name, "."))
+	lower := strings.ToLower(name)
+	if lower == suffix || strings.HasSuffix(lower, "."+suffix) {
+		return name + "."
+	}
+
+	return zone.FQDN(name)
+}
+
+// visible returns zone with only the records the user in ctx may read.
+func visible(ctx context.Context, zone Zone) Zone {
+	if _, ok := authz.FromContext(ctx); !ok {
+		return zone
+	}
+
+	records := []Record{}
+	for _, record := range zone.Records {
+		if authz.Authorize(ctx, authz.Read, zone.FQDN(record.Name), record.RRType) == nil {
+			records = append(records, record)
+		}
+	}
+
+	zone.Records = records
+	return zone
+}
@@ -0,0 +1,170 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+	"zoneupdated/authz"
+	"zoneupdated/httperror"
+)
+
+// Changes is a batch of records to remove from and add to the zone, which are
+// made together. Names may be relative to the zone or fully qualified.
+type Changes struct {
+	Delete []Record
+	Create []Record
+}
+
+// Record types whose values are names, which may be relative to the zone
+var nameValueTypes = map[string]bool{"CNAME": true, "DNAME": true, "NS": true, "PTR": true}
+
+// ApplyChanges removes and adds records while holding the lock, making either
+// all of the changes or none of them. If check is not nil it is called with
+// the current zone first, and can refuse the changes by returning an error.
+func (updater *Updater) ApplyChanges(ctx context.Context, changes Changes, check func(zone Zone) error) (result Result, err error) {
+	started := time.Now()
+	batch := batchChanges(changes)
+	defer func() {
+		updater.audit(ctx, started, false, batch, result, err)
+	}()
+
+	return updater.rewrite(ctx, false, func(currentFile io.Reader, newFile io.Writer) (Result, error) {
+		current, err := ioutil.ReadAll(currentFile)
+		if err != nil {
+			return Result{}, err
+		}
+
+		zone, err := updater.parseZone(bytes.NewReader(current))
+		if err != nil {
+			return Result{}, err
+		}
+
+		for _, c := range batch {
+			c.rrset(zone)
+		}
+
+		if check != nil {
+			if err = check(zone); err != nil {
+				return Result{}, err
+			}
+		}
+
+		for _, record := range changes.Delete {
+			if err := authz.Authorize(ctx, authz.Cleanup, zone.FQDN(record.Name), record.RRType); err != nil {
+				return Result{}, err
+			}
+			if err := updater.limit(authz.Cleanup, zone.FQDN(record.Name)); err != nil {
+				return Result{}, err
+			}
+		}
+		for _, record := range changes.Create {
+			if err := authz.Authorize(ctx, authz.Create, zone.FQDN(record.Name), record.RRType); err != nil {
+				return Result{}, err
+			}
+			if err := updater.limit(authz.Create, zone.FQDN(record.Name)); err != nil {
+				return Result{}, err
+			}
+		}
+
+		currentLines, err := readLines(bytes.NewReader(current))
+		if err != nil {
+			return Result{}, err
+		}
+
+		deleted := make(map[int][]string, len(changes.Delete))
+		for _, record := range changes.Delete {
+			found, ok := findRecord(zone, record, deleted)
+			if !ok {
+				err := notFoundError{fmt.Sprintf("Did not find record for %s with RRTYPE %s and value %s",
+					record.Name, record.RRType, record.Value)}
+				return Result{}, httperror.Error(http.StatusNotFound, err)
+			}
+			removeRecord(deleted, currentLines, found)
+		}
+
+		var lines []string
+		for _, record := range changes.Create {
+			// Creating a record that is already there changes nothing
+			if _, ok := findRecord(zone, record, deleted); ok {
+				continue
+			}
+
+			record.Name = zone.RelativeName(record.Name)
+			record.RRType = strings.ToUpper(record.RRType)
+			record.Enabled = true
+			lines = append(lines, formatRecord(record))
+		}
+
+		return updater.copyAndChange(bytes.NewReader(current), newFile, deleted, lines)
+	})
+}
+
+// findRecord finds an enabled record with the same name, type and value as
+// record, ignoring those on lines in skip.
+func findRecord(zone Zone, record Record, skip map[int][]string) (Record, bool) {
+	fqdn := zone.FQDN(record.Name)
+	for _, existing := range zone.Records {
+		if _, skipped := skip[existing.Line]; !existing.Enabled || skipped || !existing.IsType(record.RRType) ||
+			!strings.EqualFold(zone.FQDN(existing.Name), fqdn) {
+			continue
+		}
+
+		if existing.Value == record.Value || nameValueTypes[existing.RRType] &&
+			strings.EqualFold(zone.FQDN(existing.Value), zone.FQDN(record.Value)) {
+			return existing, true
+		}
+	}
+
+	return Record{}, false
+}
+
+// copyAndChange copies the zone file, replacing the lines numbered in replaced
+// (counting from 1) with their new lines, or leaving them out if there are
+// none, and adding appended to the end.
+func (updater *Updater) copyAndChange(currentFile io.Reader, newFile io.Writer, replaced map[int][]string, appended []string) (Result, error) {
+	changed := len(appended) > 0
+	copier := zoneCopier{updater: updater, newFile: newFile}
+
+	current, err := readLines(currentFile)
+	if err != nil {
+		return Result{}, err
+	}
+
+	for i, line := range current {
+		isSerial, err := copier.copySerial(line)
+		if err != nil {
+			return Result{}, err
+		}
+		if isSerial {
+			continue
+		}
+
+		newLines, ok := replaced[i+1]
+		if !ok {
+			newLines = []string{line}
+		} else if len(newLines) != 1 || newLines[0] != line {
+			changed = true
+		}
+
+		for _, newLine := range newLines {
+			err = copier.writeLine(newLine)
+			if err != nil {
+				return Result{}, err
+			}
+		}
+	}
+
+	for _, line := range appended {
+		err := copier.writeLine(line)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	return copier.result(changed), nil
+}
@@ -0,0 +1,198 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+	"zoneupdated/authz"
+)
+
+// Delegation describes how to point fqdn at the dynamic zone using a CNAME
+// to its hash, so that updates for fqdn can be made in the dynamic zone.
+type Delegation struct {
+	FQDN        string `json:"fqdn"`
+	Label       string `json:"label"`
+	CNAME       string `json:"cname"`
+	Placeholder string `json:"placeholder"`
+	Exists      bool   `json:"exists"`
+	Appended    bool   `json:"appended"`
+}
+
+var placeholderValues = map[string]string{
+	"A":    "0.0.0.0",
+	"AAAA": "::",
+}
+
+// Delegations returns the CNAME records for the parent zones and the
+// placeholder records for this zone needed to delegate updates for fqdns. If
+// appendPlaceholders is set, placeholders are added to the zone file for any
+// that don't already exist.
+func (updater *Updater) Delegations(ctx context.Context, fqdns []string, rrtype string, appendPlaceholders bool) (delegations []Delegation, result Result, err error) {
+	if appendPlaceholders {
+		started := time.Now()
+		defer func() {
+			updater.audit(ctx, started, false, updater.placeholderChanges(fqdns, rrtype, delegations, err), result, err)
+		}()
+	}
+
+	op := authz.Read
+	if appendPlaceholders {
+		op = authz.Create
+	}
+	for _, fqdn := range fqdns {
+		if err := updater.authorize(ctx, op, fqdn, rrtype); err != nil {
+			return nil, Result{}, err
+		}
+	}
+
+	if !appendPlaceholders {
+		zone, err := updater.readZone(ctx)
+		if err != nil {
+			return nil, Result{}, err
+		}
+
+		delegations = updater.delegations(zone, fqdns, rrtype)
+		return delegations, Result{Serial: zone.Serial}, nil
+	}
+
+	result, err = updater.rewrite(ctx, false, func(currentFile io.Reader, newFile io.Writer) (Result, error) {
+		current, err := ioutil.ReadAll(currentFile)
+		if err != nil {
+			return Result{}, err
+		}
+
+		zone, err := updater.parseZone(bytes.NewReader(current))
+		if err != nil {
+			return Result{}, err
+		}
+
+		delegations = updater.delegations(zone, fqdns, rrtype)
+
+		var placeholders []string
+		for i := range delegations {
+			if !delegations[i].Exists {
+				placeholders = append(placeholders, delegations[i].Placeholder)
+				delegations[i].Appended = true
+			}
+		}
+
+		return updater.copyAndAppend(bytes.NewReader(current), newFile, placeholders)
+	})
+
+	return delegations, result, err
+}
+
+// placeholderChanges are the placeholders appended for delegations, or that
+// would have been if there was an error.
+func (updater *Updater) placeholderChanges(fqdns []string, rrtype string, delegations []Delegation, err error) []*change {
+	var changes []*change
+	for i, fqdn := range fqdns {
+		if err != nil || i < len(delegations) && delegations[i].Appended {
+			changes = append(changes, &change{name: updater.labels(withTrailingDot(fqdn))[0], rrtype: rrtype, op: authz.Create})
+		}
+	}
+
+	return changes
+}
+
+func (updater *Updater) delegations(zone Zone, fqdns []string, rrtype string) []Delegation {
+	rrtype = strings.ToUpper(rrtype)
+
+	delegations := make([]Delegation, 0, len(fqdns))
+	for _, fqdn := range fqdns {
+		// The CNAME's owner must be fully qualified in the parent zone, and the
+		// label is hashed from the same name, so that it is the same whether or
+		// not fqdn was given with the trailing dot
+		name := withTrailingDot(fqdn)
+		labels := updater.labels(name)
+		label := labels[0]
+		delegation := Delegation{
+			FQDN:        fqdn,
+			Label:       label,
+			CNAME:       fmt.Sprintf("%s\tIN CNAME\t%s.%s", name, label, zone.Name),
+			Placeholder: formatRecord(Placeholder(label, rrtype)),
+		}
+
+		for _, record := range zone.Records {
+			if record.Matches(rrtype, append(labels, fqdn)...) {
+				delegation.Exists = true
+				break
+			}
+		}
+
+		delegations = append(delegations, delegation)
+	}
+
+	return delegations
+}
+
+// Placeholder returns a disabled record with a dummy value, that can be added
+// to the zone so that it can be updated later.
+func Placeholder(name string, rrtype string) Record {
+	rrtype = strings.ToUpper(rrtype)
+
+	value, ok := placeholderValues[rrtype]
+	if !ok {
+		value = "placeholder"
+	}
+
+	return Record{Name: name, RRType: rrtype, Value: value}
+}
+
+// AppendRecords adds records to the end of the zone file.
+func (updater *Updater) AppendRecords(ctx context.Context, records []Record) (result Result, err error) {
+	started := time.Now()
+	lines := make([]string, 0, len(records))
+	appended := make([]*change, 0, len(records))
+	for _, record := range records {
+		lines = append(lines, formatRecord(record))
+		appended = append(appended, &change{name: record.Name, rrtype: record.RRType, op: authz.Create,
+			new: enabledValues([]Record{record})})
+	}
+	defer func() {
+		updater.audit(ctx, started, false, appended, result, err)
+	}()
+
+	return updater.rewrite(ctx, false, func(currentFile io.Reader, newFile io.Writer) (Result, error) {
+		current, err := ioutil.ReadAll(currentFile)
+		if err != nil {
+			return Result{}, err
+		}
+
+		zone, err := updater.parseZone(bytes.NewReader(current))
+		if err != nil {
+			return Result{}, err
+		}
+
+		for _, record := range records {
+			if err := authz.Authorize(ctx, authz.Create, zone.FQDN(record.Name), record.RRType); err != nil {
+				return Result{}, err
+			}
+		}
+
+		return updater.copyAndAppend(bytes.NewReader(current), newFile, lines)
+	})
+}
+
+func formatRecord(record Record) string {
+	disableComment := ""
+	if !record.Enabled {
+		disableComment = ";"
+	}
+
+	ttl := ""
+	if record.TTL != 0 {
+		ttl = fmt.Sprint(record.TTL, " ")
+	}
+
+	return fmt.Sprintf("%s%s\t%sIN %s\t%s", disableComment, record.Name, ttl, record.RRType, quoteValue(record.Value))
+}
+
+// copyAndAppend copies the zone file, adding lines to the end.
+func (updater *Updater) copyAndAppend(currentFile io.Reader, newFile io.Writer, lines []string) (Result, error) {
+	return updater.copyAndChange(currentFile, newFile, nil, lines)
+}
@@ -0,0 +1,112 @@
+package updater
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"hash"
+	"strings"
+	"zoneupdated/config"
+)
+
+// HashScheme controls how the label a CNAME points to is derived from an fqdn.
+// The zero value is the legacy scheme: the base32 encoded SHA-1 of the fqdn
+// exactly as given.
+type HashScheme struct {
+	Algorithm string
+	Length    int
+	Lowercase bool
+	Salt      string
+	Normalize bool
+}
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	"":       sha1.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// newHashScheme creates the configured HashScheme. The configuration must already
+// have been checked by config.ValidateConfig.
+func newHashScheme(conf config.Config) HashScheme {
+	return HashScheme{
+		Algorithm: strings.ToLower(conf.HashAlgorithm),
+		Length:    conf.HashLength,
+		Lowercase: conf.HashLowercase,
+		Salt:      conf.HashSalt,
+		Normalize: conf.HashNormalize,
+	}
+}
+
+// Label returns the hashed label for fqdn.
+func (scheme HashScheme) Label(fqdn string) string {
+	if scheme.Normalize {
+		fqdn = normalizeName(fqdn)
+	}
+
+	newHash := hashAlgorithms[scheme.Algorithm]
+	var h hash.Hash
+	if scheme.Salt != "" {
+		h = hmac.New(newHash, []byte(scheme.Salt))
+	} else {
+		h = newHash()
+	}
+	_, _ = h.Write([]byte(fqdn))
+
+	// Padding isn't allowed in DNS labels, and SHA-1 never needed it anyway
+	label := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(h.Sum(nil))
+	if scheme.Length != 0 && scheme.Length < len(label) {
+		label = label[:scheme.Length]
+	}
+	if scheme.Lowercase {
+		label = strings.ToLower(label)
+	}
+
+	return label
+}
+
+// normalizeName lowercases a name and makes sure it ends with a dot, so that
+// different ways of writing the same name hash the same.
+func normalizeName(fqdn string) string {
+	return withTrailingDot(strings.ToLower(strings.TrimSpace(fqdn)))
+}
+
+// withTrailingDot returns fqdn ending with a dot, as a fully qualified name.
+func withTrailingDot(fqdn string) string {
+	if !strings.HasSuffix(fqdn, ".") {
+		fqdn += "."
+	}
+
+	return fqdn
+}
+
+// labels returns the labels that updates for fqdn should look for: the
+// configured scheme's, also for fqdn with a trailing dot if it doesn't have
+// one, as delegations are always made for that, and during a migration, the
+// legacy one.
+func (updater *Updater) labels(fqdn string) []string {
+	labels := []string{updater.hashScheme.Label(fqdn)}
+	labels = appendLabel(labels, updater.hashScheme.Label(withTrailingDot(fqdn)))
+	if updater.conf.HashAcceptLegacy {
+		labels = appendLabel(labels, cNameHash(fqdn))
+	}
+
+	return labels
+}
+
+// appendLabel adds label to labels unless it is already there.
+func appendLabel(labels []string, label string) []string {
+	for _, l := range labels {
+		if strings.EqualFold(l, label) {
+			return labels
+		}
+	}
+
+	return append(labels, label)
+}
+
+func cNameHash(fqdn string) string {
+	sum := sha1.Sum([]byte(fqdn))
+	return base32.StdEncoding.EncodeToString(sum[:])
+}
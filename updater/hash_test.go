@@ -0,0 +1,65 @@
+package updater_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"zoneupdated/config"
+	"zoneupdated/updater"
+)
+
+func TestHashScheme_Legacy(t *testing.T) {
+	checkLabel(t, updater.HashScheme{}, "_acme-challenge.example.com", "MKW4X6GK7F3M2IKUUZX7X6LMYJB4HOZY")
+	checkLabel(t, updater.HashScheme{Algorithm: "sha1"}, "test", "VFFI7ZOMWGN2MHCMBBZ5HEPJQ6MC7O6T")
+}
+
+func TestHashScheme_Options(t *testing.T) {
+	sha256 := updater.HashScheme{Algorithm: "sha256"}
+	label := sha256.Label("test")
+	if len(label) != 52 || strings.ContainsRune(label, '=') {
+		t.Errorf("Expected 52 character SHA-256 label without padding but got '%s'", label)
+	}
+
+	checkLabel(t, updater.HashScheme{Algorithm: "sha256", Length: 20, Lowercase: true}, "test",
+		strings.ToLower(label[:20]))
+
+	normalized := updater.HashScheme{Normalize: true}
+	checkLabel(t, normalized, "Example.COM", normalized.Label("example.com."))
+
+	salted := updater.HashScheme{Salt: "secret"}
+	if salted.Label("test") == (updater.HashScheme{}).Label("test") {
+		t.Error("Salt should have changed the label")
+	}
+	if salted.Label("test") == (updater.HashScheme{Salt: "other"}).Label("test") {
+		t.Error("Different salts should give different labels")
+	}
+}
+
+func TestUpdater_HashAcceptLegacy(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	conf := config.Config{ZoneFileName: zoneFile, HashAlgorithm: "sha256", HashLowercase: true}
+	u := updater.New(conf)
+
+	// VFFI7ZOMWGN2MHCMBBZ5HEPJQ6MC7O6T is the legacy hash of "test"
+	_, err := u.Update(context.TODO(), updater.UpdateRequest{FQDN: "test", RRType: "TXT", Value: "bar"})
+	if err == nil {
+		t.Error("Legacy label should not match unless enabled")
+	}
+
+	conf.HashAcceptLegacy = true
+	u = updater.New(conf)
+
+	_, err = u.Update(context.TODO(), updater.UpdateRequest{FQDN: "test", RRType: "TXT", Value: "bar"})
+	if err != nil {
+		t.Errorf("Legacy label should match during migration, but got %s", err)
+	}
+}
+
+func checkLabel(t *testing.T, scheme updater.HashScheme, fqdn string, expected string) {
+	label := scheme.Label(fqdn)
+	if label != expected {
+		t.Errorf("Expected label '%s' for '%s' with %+v but got '%s'", expected, fqdn, scheme, label)
+	}
+}
@@ -0,0 +1,70 @@
+package updater
+
+import (
+	"github.com/gofrs/flock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"time"
+)
+
+// Lock waits and holds are often well under the 5ms of the default buckets
+var durationBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	updatesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoneupdated_updates_total",
+		Help: "Changes to the records of a name and type attempted, by outcome.",
+	}, []string{"outcome"})
+	lockWaitSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zoneupdated_lock_wait_seconds",
+		Help:    "Time spent waiting for the zone lock, by mode.",
+		Buckets: durationBuckets,
+	}, []string{"mode"})
+	lockHoldSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zoneupdated_lock_hold_seconds",
+		Help:    "Time the zone lock was held for, by mode.",
+		Buckets: durationBuckets,
+	}, []string{"mode"})
+	commitSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zoneupdated_commit_duration_seconds",
+		Help:    "Time taken to replace the zone file with the updated one.",
+		Buckets: durationBuckets,
+	}, []string{"zone"})
+	zoneFileBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zoneupdated_zone_file_bytes",
+		Help: "Size of the zone file.",
+	}, []string{"zone"})
+	zoneSerial = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zoneupdated_zone_serial",
+		Help: "Serial of the zone.",
+	}, []string{"zone"})
+)
+
+// zoneLock is a held zone lock, which records how long it was held.
+type zoneLock struct {
+	*flock.Flock
+	mode   string
+	locked time.Time
+}
+
+func (lock zoneLock) Unlock() error {
+	lockHoldSeconds.WithLabelValues(lock.mode).Observe(time.Since(lock.locked).Seconds())
+	return lock.Flock.Unlock()
+}
+
+func lockMode(exclusive bool) string {
+	if exclusive {
+		return "exclusive"
+	}
+
+	return "shared"
+}
+
+// observeZone records the size of the zone file and the serial.
+func (updater *Updater) observeZone(size int64, serial uint32) {
+	zone := updater.zoneName("")
+	zoneFileBytes.WithLabelValues(zone).Set(float64(size))
+	if serial != 0 {
+		zoneSerial.WithLabelValues(zone).Set(float64(serial))
+	}
+}
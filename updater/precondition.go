@@ -0,0 +1,62 @@
+package updater
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"zoneupdated/httperror"
+)
+
+// PreconditionError is returned, wrapped in an HttpError, when an update's
+// preconditions don't hold. It describes the current state of the records.
+type PreconditionError struct {
+	Reason  string   `json:"-"`
+	Serial  uint32   `json:"serial"`
+	Records []Record `json:"records"`
+}
+
+func (err *PreconditionError) Error() string {
+	var values []string
+	for _, record := range err.Records {
+		if record.Enabled {
+			values = append(values, record.Value)
+		}
+	}
+
+	return fmt.Sprintf("Precondition failed: %s (serial %d, current values [%s])",
+		err.Reason, err.Serial, strings.Join(values, ", "))
+}
+
+// checkPreconditions checks the preconditions of updateRequest against the
+// zone and the records matching it.
+func (updateRequest UpdateRequest) checkPreconditions(zone Zone, current []Record) error {
+	enabled := false
+	hasValue := false
+	for _, record := range current {
+		if record.Enabled {
+			enabled = true
+			if updateRequest.ExpectValue != nil && record.Value == *updateRequest.ExpectValue {
+				hasValue = true
+			}
+		}
+	}
+
+	reason := ""
+	switch {
+	case updateRequest.IfSerial != nil && *updateRequest.IfSerial != zone.Serial:
+		reason = fmt.Sprintf("serial is not %d", *updateRequest.IfSerial)
+	case updateRequest.ExpectAbsent && enabled:
+		reason = "record is present"
+	case updateRequest.ExpectEnabled != nil && *updateRequest.ExpectEnabled && !enabled:
+		reason = "record is not enabled"
+	case updateRequest.ExpectEnabled != nil && !*updateRequest.ExpectEnabled && enabled:
+		reason = "record is enabled"
+	case updateRequest.ExpectValue != nil && !hasValue:
+		reason = fmt.Sprintf("record does not have value %s", *updateRequest.ExpectValue)
+	default:
+		return nil
+	}
+
+	return httperror.WithCode(http.StatusPreconditionFailed, httperror.PreconditionFailed,
+		&PreconditionError{Reason: reason, Serial: zone.Serial, Records: current})
+}
@@ -0,0 +1,54 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"zoneupdated/atomicfile"
+)
+
+// Ready checks that the zone lock can be taken, that the zone file can be
+// read and parsed and has a serial, and that the zone file can be replaced,
+// returning an error for each check that fails, or nil.
+func (updater *Updater) Ready(ctx context.Context) map[string]error {
+	checks := make(map[string]error)
+
+	lockfile, err := updater.lock(ctx, false)
+	if err == nil {
+		_ = lockfile.Unlock()
+	}
+	checks["lock"] = err
+
+	zone, err := updater.readZone(ctx)
+	if err == nil && zone.Serial == 0 {
+		err = errors.New("zone file has no SOA serial")
+	}
+	checks["zone_file"] = err
+	checks["writable"] = updater.writable()
+
+	return checks
+}
+
+// writable checks that the zone file can be replaced: that the directory is
+// writable for the rename strategies, and the file itself for copying in place.
+func (updater *Updater) writable() error {
+	if updater.commitStrategy == atomicfile.Copy {
+		file, err := os.OpenFile(updater.conf.ZoneFileName, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		return file.Close()
+	}
+
+	dir := filepath.Dir(updater.conf.ZoneFileName)
+	file, err := ioutil.TempFile(dir, ".zoneupdated-ready-")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %s", dir, err)
+	}
+	_ = file.Close()
+
+	return os.Remove(file.Name())
+}
@@ -0,0 +1,242 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+	"zoneupdated/authz"
+)
+
+// RRsetEdit returns the new records of an RRset given the zone and the records
+// currently in it, which include disabled ones. It can refuse the edit by
+// returning an error.
+type RRsetEdit func(zone Zone, current []Record) ([]Record, error)
+
+// recordFinder returns the records that an edit changes, given the zone and
+// its lines.
+type recordFinder func(zone Zone, lines []string) ([]Record, error)
+
+// RRset returns the records, enabled or not, with name and rrtype. The name
+// may be relative to the zone or fully qualified.
+func (zone Zone) RRset(name string, rrtype string) []Record {
+	fqdn := zone.FQDN(name)
+
+	records := []Record{}
+	for _, record := range zone.Records {
+		if record.IsType(rrtype) && strings.EqualFold(zone.FQDN(record.Name), fqdn) {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+// EditRRset replaces the records with name and rrtype by those returned by
+// edit, while holding the lock, as editRecords does. It returns the zone as it
+// is after the edit, so that callers can describe the RRset they wrote
+// without reading the zone again once the lock is released.
+func (updater *Updater) EditRRset(ctx context.Context, name string, rrtype string, edit RRsetEdit) (Result, Zone, error) {
+	started := time.Now()
+	attempted := &change{name: name, rrtype: rrtype, op: authz.Present}
+
+	var fqdn string
+	find := func(zone Zone, lines []string) ([]Record, error) {
+		// Check the name and type first, so that nothing about them is
+		// revealed to users who may not use them at all
+		fqdn = zone.FQDN(name)
+		if err := authz.Authorize(ctx, "", fqdn, rrtype); err != nil {
+			return nil, err
+		}
+
+		return zone.RRset(name, rrtype), nil
+	}
+
+	result, edited, err := updater.editRecords(ctx, false, attempted, find,
+		func(zone Zone, existing []Record) ([]Record, error) {
+			records, err := edit(zone, existing)
+			if err != nil {
+				return nil, err
+			}
+
+			op := rrsetOperation(existing, records)
+			attempted.op = op
+			if err := authz.Authorize(ctx, op, fqdn, rrtype); err != nil {
+				return nil, err
+			}
+			if err := updater.limit(op, fqdn); err != nil {
+				return nil, err
+			}
+
+			for i := range records {
+				records[i].Name = zone.RelativeName(name)
+				records[i].RRType = strings.ToUpper(rrtype)
+			}
+
+			return records, nil
+		})
+
+	updater.audit(ctx, started, false, []*change{attempted}, result, err)
+
+	return result, edited, err
+}
+
+// editRecords replaces the records found by find with those returned by edit,
+// while holding the lock, and records their values before and after in
+// attempted. The new records take the places of the existing ones, or are
+// added to the end of the zone file if there were none. Records that haven't
+// changed are left as they were written, and changed ones keep how their
+// names, TTLs and classes were written unless their TTLs change. The zone is
+// returned as it is after the edit.
+func (updater *Updater) editRecords(ctx context.Context, dryRun bool, attempted *change, find recordFinder, edit RRsetEdit) (Result, Zone, error) {
+	var edited Zone
+
+	result, err := updater.rewrite(ctx, dryRun, func(currentFile io.Reader, newFile io.Writer) (Result, error) {
+		current, err := ioutil.ReadAll(currentFile)
+		if err != nil {
+			return Result{}, err
+		}
+
+		zone, err := updater.parseZone(bytes.NewReader(current))
+		if err != nil {
+			return Result{}, err
+		}
+
+		currentLines, err := readLines(bytes.NewReader(current))
+		if err != nil {
+			return Result{}, err
+		}
+
+		existing, err := find(zone, currentLines)
+		if err != nil {
+			return Result{}, err
+		}
+		attempted.old = enabledValues(existing)
+
+		records, err := edit(zone, existing)
+		if err != nil {
+			return Result{}, err
+		}
+		attempted.new = enabledValues(records)
+
+		lines := make([][]string, 0, len(records))
+		for i, record := range records {
+			switch {
+			case i < len(existing) && sameRecord(existing[i], record):
+				lines = append(lines, recordLines(currentLines, existing[i]))
+			case i < len(existing):
+				lines = append(lines, []string{replaceRecord(currentLines, existing[i], record)})
+			default:
+				lines = append(lines, []string{formatRecord(record)})
+			}
+		}
+
+		// Each existing record, with any lines it continues onto, is replaced by
+		// the new one in the same place, and any extra new records follow the
+		// last one
+		var appended []string
+		replaced := make(map[int][]string, len(existing))
+		for i, record := range existing {
+			removeRecord(replaced, currentLines, record)
+			switch {
+			case i >= len(lines):
+			case i == len(existing)-1:
+				replaced[record.Line] = flatten(lines[i:])
+			default:
+				replaced[record.Line] = lines[i]
+			}
+		}
+		if len(existing) == 0 {
+			appended = flatten(lines)
+		}
+
+		var written bytes.Buffer
+		result, err := updater.copyAndChange(bytes.NewReader(current), io.MultiWriter(newFile, &written), replaced, appended)
+		if err != nil {
+			return result, err
+		}
+
+		for i, record := range existing {
+			if i < len(lines) {
+				result.Lines = append(result.Lines, record.Line)
+				result.Matched = append(result.Matched, lines[i][0])
+			}
+		}
+
+		edited = zone
+		if result.Changed {
+			edited, err = updater.parseZone(&written)
+		}
+
+		return result, err
+	})
+
+	return result, edited, err
+}
+
+// rrsetOperation is what replacing existing with records amounts to: creating
+// an RRset, removing it, or changing an existing one.
+func rrsetOperation(existing []Record, records []Record) authz.Operation {
+	switch {
+	case len(records) == 0:
+		return authz.Cleanup
+	case len(existing) == 0:
+		return authz.Create
+	default:
+		return authz.Present
+	}
+}
+
+// sameRecord checks whether replacing existing with record would change
+// anything. A TTL of 0 means any TTL.
+func sameRecord(existing Record, record Record) bool {
+	return existing.Value == record.Value && existing.Enabled == record.Enabled &&
+		(record.TTL == 0 || record.TTL == existing.TTL)
+}
+
+// replaceRecord returns the line for record in place of existing, keeping how
+// the name, TTL and class of existing were written unless the TTL changes.
+func replaceRecord(lines []string, existing Record, record Record) string {
+	if record.TTL == 0 || record.TTL == existing.TTL {
+		recordMatcher, err := newRecordMatcher(existing.Name, nil, existing.RRType)
+		if err == nil {
+			if groups := recordMatcher.FindStringSubmatch(lines[existing.Line-1]); groups != nil {
+				return recordMatch{groups: groups}.withValue(record.Value, record.Enabled)
+			}
+		}
+	}
+
+	return formatRecord(record)
+}
+
+// recordLines returns the lines of record in lines, which continue while its
+// parentheses are open, as when the zone file is parsed.
+func recordLines(lines []string, record Record) []string {
+	end := record.Line
+	text := stripComment(lines[end-1])
+	for record.Enabled && unbalanced(text) && end < len(lines) {
+		end++
+		text += " " + stripComment(lines[end-1])
+	}
+
+	return lines[record.Line-1 : end]
+}
+
+// removeRecord marks all of the lines of record to be left out by
+// copyAndChange.
+func removeRecord(replaced map[int][]string, lines []string, record Record) {
+	for i := range recordLines(lines, record) {
+		replaced[record.Line+i] = nil
+	}
+}
+
+func flatten(lines [][]string) []string {
+	var flat []string
+	for _, l := range lines {
+		flat = append(flat, l...)
+	}
+
+	return flat
+}
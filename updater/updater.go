@@ -2,14 +2,13 @@ package updater
 
 import (
 	"bufio"
+	"bytes"
 	"context"
-	"crypto/sha1"
-	"encoding/base32"
 	"errors"
 	"fmt"
 	"github.com/gofrs/flock"
 	"io"
-	"log"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"regexp"
@@ -17,8 +16,12 @@ import (
 	"strings"
 	"time"
 	"zoneupdated/atomicfile"
+	"zoneupdated/audit"
+	"zoneupdated/authz"
 	"zoneupdated/config"
 	"zoneupdated/httperror"
+	"zoneupdated/ratelimit"
+	"zoneupdated/unidiff"
 )
 
 type UpdateRequest struct {
@@ -26,145 +29,457 @@ type UpdateRequest struct {
 	RRType  string `json:"rrtype"`
 	Value   string `json:"value"`
 	Disable bool   `json:"-"`
+	DryRun  bool   `json:"-"`
+	// Keep this many of the most recent values, in records of the same name and type
+	Keep int `json:"-"`
+
+	// Preconditions, checked against the matching records while the zone is
+	// locked. The update is only made if all of them hold.
+	ExpectValue   *string `json:"expect_value,omitempty"`
+	ExpectAbsent  bool    `json:"expect_absent,omitempty"`
+	ExpectEnabled *bool   `json:"expect_enabled,omitempty"`
+	IfSerial      *uint32 `json:"if_serial,omitempty"`
+}
+
+// ErrNotFound is returned, wrapped in an HttpError, when there is no record
+// matching a request. Check for it with errors.Is.
+var ErrNotFound = errors.New("record not found")
+
+type notFoundError struct {
+	msg string
+}
+
+func (err notFoundError) Error() string {
+	return err.msg
+}
+
+func (err notFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+func notFound(fqdn string, labels []string, rrtype string) error {
+	return notFoundError{fmt.Sprintf("Did not find record for %s or %s with RRTYPE %s",
+		fqdn, strings.Join(labels, " or "), rrtype)}
+}
+
+// Result describes the outcome of an update. For a dry run it describes what
+// would have happened.
+type Result struct {
+	Changed bool   `json:"changed"`
+	Serial  uint32 `json:"serial"`
+	// Lines are the numbers of the lines an update matched, counting from 1
+	Lines []int `json:"lines,omitempty"`
+	// Matched are the lines an update matched, as they are after it
+	Matched []string `json:"matched,omitempty"`
+	Diff    string   `json:"diff,omitempty"`
 }
 
 type Updater struct {
-	conf          config.Config
-	lockfile      *flock.Flock
-	serialMatcher *regexp.Regexp
+	conf           config.Config
+	lockFileName   string
+	serialMatcher  *regexp.Regexp
+	commitStrategy atomicfile.Strategy
+	hashScheme     HashScheme
+	names          *ratelimit.Limiter
+	auditLog       *audit.Log
 }
 
 func New(conf config.Config) Updater {
+	// Already checked by config.ValidateConfig, and an empty value means the default
+	commitStrategy, _ := atomicfile.ParseStrategy(conf.CommitStrategy)
+
 	updater := Updater{
-		conf:          conf,
-		lockfile:      flock.New(fmt.Sprintf("%s.lock", conf.ZoneFileName)),
-		serialMatcher: regexp.MustCompile("(?i)^(\\s*)(\\d+)(\\s*;\\s*serial\\s*)$"),
+		conf:           conf,
+		lockFileName:   fmt.Sprintf("%s.lock", conf.ZoneFileName),
+		serialMatcher:  regexp.MustCompile("(?i)^(\\s*)(\\d+)(\\s*;\\s*serial\\s*)$"),
+		commitStrategy: commitStrategy,
+		hashScheme:     newHashScheme(conf),
+	}
+
+	if conf.RateLimitName > 0 {
+		updater.names = ratelimit.New("name", conf.RateLimitName)
 	}
 
 	return updater
 }
 
-func (updater *Updater) Update(ctx context.Context, updateRequest UpdateRequest) error {
-	success, err := updater.lockfile.TryLockContext(ctx, time.Second)
-	if !success {
-		if err == nil {
-			return fmt.Errorf("unknown error")
-		} else {
-			return httperror.Error(http.StatusConflict, err)
+// NameLimiter is the rate limit for changes to each name, or nil if there is none.
+func (updater *Updater) NameLimiter() *ratelimit.Limiter {
+	return updater.names
+}
+
+func (updater *Updater) Update(ctx context.Context, updateRequest UpdateRequest) (result Result, err error) {
+	op := authz.Present
+	if updateRequest.Disable {
+		op = authz.Cleanup
+	}
+
+	started := time.Now()
+	attempted := &change{name: updateRequest.FQDN, rrtype: updateRequest.RRType, op: op}
+	defer func() {
+		updater.audit(ctx, started, updateRequest.DryRun, []*change{attempted}, result, err)
+	}()
+
+	if err := updater.authorize(ctx, op, updateRequest.FQDN, updateRequest.RRType); err != nil {
+		return Result{}, err
+	}
+
+	find := func(zone Zone, lines []string) ([]Record, error) {
+		records, err := updater.matchRecords(zone, lines, updateRequest.FQDN, updateRequest.RRType)
+		if errors.Is(err, ErrNotFound) {
+			return nil, httperror.WithCode(http.StatusBadRequest, httperror.RecordNotFound, err)
 		}
+		return records, err
+	}
+
+	result, _, err = updater.editRecords(ctx, updateRequest.DryRun, attempted, find, updateRequest.edit)
+	return result, err
+}
+
+// zoneEdit copies the current zone file to the new one, making changes on the way.
+type zoneEdit func(currentFile io.Reader, newFile io.Writer) (Result, error)
+
+// rewrite passes the zone file through edit while holding the lock, and
+// replaces the zone file with the result if edit reports a change.
+func (updater *Updater) rewrite(ctx context.Context, dryRun bool, edit zoneEdit) (Result, error) {
+	lockfile, err := updater.lock(ctx, true)
+	if err != nil {
+		return Result{}, err
+	}
+	defer lockfile.Unlock()
+
+	if dryRun {
+		return updater.dryRun(edit)
 	}
-	defer updater.lockfile.Unlock()
 
 	zoneFile, err := os.Open(updater.conf.ZoneFileName)
 	if err != nil {
-		return fmt.Errorf("Unable to open zone file: %s", err)
+		return Result{}, fmt.Errorf("Unable to open zone file: %s", err)
 	}
 	defer zoneFile.Close()
 
-	newZoneFile, err := atomicfile.Open(updater.conf.ZoneFileName)
+	newZoneFile, err := atomicfile.OpenWithStrategy(updater.conf.ZoneFileName, updater.commitStrategy)
 	if err != nil {
-		return fmt.Errorf("Unable to open temporary file: %s", err)
+		return Result{}, fmt.Errorf("Unable to open temporary file: %s", err)
 	}
 
-	changed, err := updater.copyAndUpdate(zoneFile, newZoneFile, updateRequest)
+	result, err := edit(zoneFile, newZoneFile)
 
 	if updater.conf.TestMode {
 		newZoneFile.Close()
-	} else if changed {
-		return newZoneFile.Commit()
+	} else if result.Changed {
+		committing := time.Now()
+		if err := newZoneFile.Commit(); err != nil {
+			return result, err
+		}
+		commitSeconds.WithLabelValues(updater.zoneName("")).Observe(time.Since(committing).Seconds())
+
+		if info, err := os.Stat(updater.conf.ZoneFileName); err == nil {
+			updater.observeZone(info.Size(), result.Serial)
+		}
+		return result, nil
 	} else {
 		_ = newZoneFile.Abort()
 	}
-	return err
+	return result, err
 }
 
-func (updater *Updater) copyAndUpdate(currentFile io.Reader, newFile *atomicfile.AtomicFile, updateRequest UpdateRequest) (bool, error) {
-	found := false
-	changed := false
+// Zone returns the current serial and the records in the zone file that the
+// user in ctx may read.
+func (updater *Updater) Zone(ctx context.Context) (Zone, error) {
+	zone, err := updater.readZone(ctx)
+	if err != nil {
+		return Zone{}, err
+	}
 
-	hash := cNameHash(updateRequest.FQDN)
-	newValue := updateRequest.Value
-	numFields := len(strings.Fields(newValue))
-	if numFields != 1 || strings.ContainsRune(newValue, '"') {
-		// quote the string
-		newValue = fmt.Sprint("\"", strings.ReplaceAll(newValue, "\"", "\\\""), "\"")
+	return visible(ctx, zone), nil
+}
+
+// readZone returns the current serial and all of the records in the zone file.
+func (updater *Updater) readZone(ctx context.Context) (Zone, error) {
+	zone, _, err := updater.readZoneLines(ctx)
+	return zone, err
+}
+
+// readZoneLines returns the records in the zone file together with its lines,
+// read while holding the lock so that they describe the same file.
+func (updater *Updater) readZoneLines(ctx context.Context) (Zone, []string, error) {
+	lockfile, err := updater.lock(ctx, false)
+	if err != nil {
+		return Zone{}, nil, err
 	}
+	defer lockfile.Unlock()
 
-	recordMatchRegex := fmt.Sprintf("(?i)^(\\s*;)?(\\s*)(%s|%s)(\\s*\\d+)?(\\s*IN)?(\\s*%s)(\\s*)",
-		updateRequest.FQDN, hash, updateRequest.RRType)
+	contents, err := ioutil.ReadFile(updater.conf.ZoneFileName)
+	if err != nil {
+		return Zone{}, nil, fmt.Errorf("Unable to open zone file: %s", err)
+	}
 
-	recordMatcher, err := regexp.Compile(recordMatchRegex)
+	zone, err := updater.parseZone(bytes.NewReader(contents))
 	if err != nil {
-		return false, err
+		return Zone{}, nil, err
 	}
 
-	scanner := bufio.NewScanner(currentFile)
-	scanner.Split(bufio.ScanLines)
+	lines, err := readLines(bytes.NewReader(contents))
+	if err != nil {
+		return Zone{}, nil, err
+	}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		// Update serial number
-		groups := updater.serialMatcher.FindStringSubmatch(line)
-		if groups != nil {
-			serial, err := getSerial(groups[2])
-			if err != nil {
-				return false, err
-			}
-
-			if !updater.conf.SequentialSerial {
-				timeSerial := timeBasedSerial()
-				if timeSerial > serial {
-					serial = timeSerial
-				}
-			}
-
-			_, err = fmt.Fprintf(newFile, "%s%d%s\n", groups[1], serial+1, groups[3])
-			if err != nil {
-				return false, err
-			}
+	updater.observeZone(int64(len(contents)), zone.Serial)
+	return zone, lines, nil
+}
+
+// Lookup finds the records that an update for fqdn and rrtype would change,
+// matching their lines as the update does.
+func (updater *Updater) Lookup(ctx context.Context, fqdn string, rrtype string) (Zone, error) {
+	if err := updater.authorize(ctx, authz.Read, fqdn, rrtype); err != nil {
+		return Zone{}, err
+	}
+
+	zone, lines, err := updater.readZoneLines(ctx)
+	if err != nil {
+		return Zone{}, err
+	}
+
+	records, err := updater.matchRecords(zone, lines, fqdn, rrtype)
+	if err != nil {
+		return Zone{}, httperror.Error(http.StatusNotFound, err)
+	}
+
+	zone.Records = records
+	return zone, nil
+}
+
+// Resolve returns the first of names that an update for rrtype would find in
+// the zone file, for callers that don't know how a name is written there, so
+// that they only need to update it once. The user's policy is checked by the
+// update, not here.
+func (updater *Updater) Resolve(ctx context.Context, names []string, rrtype string) (string, error) {
+	zone, lines, err := updater.readZoneLines(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range names {
+		_, err = updater.matchRecords(zone, lines, name, rrtype)
+		if !errors.Is(err, ErrNotFound) {
+			return name, err
+		}
+	}
+
+	return "", err
+}
+
+// matchRecords returns the records of zone whose lines an update for fqdn and
+// rrtype would change.
+func (updater *Updater) matchRecords(zone Zone, lines []string, fqdn string, rrtype string) ([]Record, error) {
+	labels := updater.labels(fqdn)
+	recordMatcher, err := newRecordMatcher(fqdn, labels, rrtype)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []Record{}
+	for _, record := range zone.Records {
+		if recordMatcher.MatchString(lines[record.Line-1]) {
+			records = append(records, record)
+		}
+	}
+
+	if len(records) == 0 {
+		return nil, notFound(fqdn, labels, rrtype)
+	}
+
+	return records, nil
+}
+
+// lock takes the zone lock, shared or exclusive. A new Flock is used each time
+// because a Flock that is already locked reports success to any other
+// goroutine trying to lock it, and shared and exclusive locks on the same file
+// descriptor would convert rather than exclude each other.
+func (updater *Updater) lock(ctx context.Context, exclusive bool) (zoneLock, error) {
+	lockfile := flock.New(updater.lockFileName)
+
+	tryLock := lockfile.TryRLockContext
+	if exclusive {
+		tryLock = lockfile.TryLockContext
+	}
+
+	mode := lockMode(exclusive)
+	waiting := time.Now()
+	success, err := tryLock(ctx, time.Second)
+	lockWaitSeconds.WithLabelValues(mode).Observe(time.Since(waiting).Seconds())
+	if !success {
+		if err == nil {
+			return zoneLock{}, fmt.Errorf("unknown error")
+		} else {
+			return zoneLock{}, httperror.WithCode(http.StatusConflict, httperror.LockTimeout, err)
+		}
+	}
+
+	return zoneLock{Flock: lockfile, mode: mode, locked: time.Now()}, nil
+}
+
+// dryRun goes through the same update process but only into memory, and
+// returns a diff of what would have been written.
+func (updater *Updater) dryRun(edit zoneEdit) (Result, error) {
+	current, err := ioutil.ReadFile(updater.conf.ZoneFileName)
+	if err != nil {
+		return Result{}, fmt.Errorf("Unable to read zone file: %s", err)
+	}
+
+	var proposed bytes.Buffer
+	result, err := edit(bytes.NewReader(current), &proposed)
+	if err != nil {
+		return result, err
+	}
+
+	if result.Changed {
+		result.Diff = unidiff.Unified(updater.conf.ZoneFileName, updater.conf.ZoneFileName,
+			unidiff.Lines(string(current)), unidiff.Lines(proposed.String()))
+	}
+
+	return result, nil
+}
+
+// newRecordMatcher returns the expression matching the lines of records for
+// fqdn, or any of its labels, and rrtype, enabled or disabled. Its groups make
+// up the start of the line up to the value.
+func newRecordMatcher(fqdn string, labels []string, rrtype string) (*regexp.Regexp, error) {
+	names := []string{regexp.QuoteMeta(fqdn)}
+	for _, label := range labels {
+		names = append(names, regexp.QuoteMeta(label))
+	}
+
+	return regexp.Compile(fmt.Sprintf("(?i)^(\\s*;)?(\\s*)(%s)(\\s+\\d+)?(\\s+IN)?(\\s+%s)(\\s+)",
+		strings.Join(names, "|"), regexp.QuoteMeta(rrtype)))
+}
+
+// recordMatch is the recordMatcher groups for a record's line, which make up
+// the start of the line up to the value.
+type recordMatch struct {
+	groups []string
+}
+
+func (match recordMatch) withValue(value string, enabled bool) string {
+	disableComment := ""
+	if !enabled {
+		disableComment = ";"
+	}
+
+	groups := match.groups
+	return fmt.Sprint(disableComment, groups[2], groups[3], groups[4], groups[5], groups[6], groups[7],
+		quoteValue(value))
+}
+
+// edit returns the records matching updateRequest as they are after it, once
+// its preconditions have been checked. Usually every record gets the new
+// value, but if Keep is more than one, the values are rotated through the
+// records instead so that the most recent ones are kept, newest first.
+func (updateRequest UpdateRequest) edit(zone Zone, current []Record) ([]Record, error) {
+	if err := updateRequest.checkPreconditions(zone, current); err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, len(current))
+	copy(records, current)
+
+	if updateRequest.Keep <= 1 || updateRequest.Disable {
+		for i := range records {
+			records[i].Value, records[i].Enabled = updateRequest.Value, !updateRequest.Disable
+		}
+		return records, nil
+	}
+
+	values := []string{updateRequest.Value}
+	for _, record := range current {
+		if record.Enabled && record.Value != updateRequest.Value {
+			values = append(values, record.Value)
+		}
+	}
+
+	for i := range records {
+		if i < len(values) && i < updateRequest.Keep {
+			records[i].Value, records[i].Enabled = values[i], true
 		} else {
-			var newLine string
-			groups := recordMatcher.FindStringSubmatch(line)
-			if groups != nil {
-				found = true
-
-				disableComment := ""
-				if updateRequest.Disable {
-					disableComment = ";"
-				}
-
-				newLine = fmt.Sprint(disableComment, groups[2], groups[3], groups[4], groups[5], groups[6], groups[7],
-					newValue)
-
-				if newLine != line {
-					changed = true
-				}
-			} else {
-				newLine = line
-			}
-
-			_, err = fmt.Fprintln(newFile, newLine)
-			if err != nil {
-				return false, err
-			}
+			records[i].Enabled = false
 		}
 	}
 
-	if !found {
-		msg := fmt.Sprintf("Did not find record for %s or %s with RRTYPE %s",
-			updateRequest.FQDN, hash, updateRequest.RRType)
-		log.Print(msg)
-		return false, httperror.Error(http.StatusBadRequest,
-			errors.New(msg))
+	return records, nil
+}
+
+func readLines(file io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(file)
+	scanner.Split(bufio.ScanLines)
+
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
 	}
 
-	return changed, nil
+	return lines, scanner.Err()
 }
 
-func cNameHash(fqdn string) string {
-	sum := sha1.Sum([]byte(fqdn))
-	return base32.StdEncoding.EncodeToString(sum[:])
+// zoneCopier writes lines to a new zone file, updating the serial number on the way.
+type zoneCopier struct {
+	updater   *Updater
+	newFile   io.Writer
+	oldSerial uint32
+	newSerial uint32
+}
+
+// copySerial writes line with the serial number updated, if it is the serial
+// line, and returns whether it was.
+func (copier *zoneCopier) copySerial(line string) (bool, error) {
+	groups := copier.updater.serialMatcher.FindStringSubmatch(line)
+	if groups == nil {
+		return false, nil
+	}
+
+	serial, err := getSerial(groups[2])
+	if err != nil {
+		return true, err
+	}
+	copier.oldSerial = serial
+
+	if !copier.updater.conf.SequentialSerial {
+		timeSerial := timeBasedSerial()
+		if timeSerial > serial {
+			serial = timeSerial
+		}
+	}
+
+	copier.newSerial = serial + 1
+	return true, copier.writeLine(fmt.Sprintf("%s%d%s", groups[1], copier.newSerial, groups[3]))
+}
+
+func (copier *zoneCopier) writeLine(line string) error {
+	_, err := fmt.Fprintln(copier.newFile, line)
+	return err
+}
+
+// result reports the serial that was written if the zone changed, otherwise the existing one.
+func (copier *zoneCopier) result(changed bool) Result {
+	if changed {
+		return Result{Changed: true, Serial: copier.newSerial}
+	}
+	return Result{Changed: false, Serial: copier.oldSerial}
+}
+
+var valueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// quoteValue quotes a value if needed to make it a single field in the zone
+// file, so that characters such as ; and ( can't start a comment or join lines.
+func quoteValue(value string) string {
+	numFields := len(strings.Fields(value))
+	if numFields != 1 || strings.ContainsAny(value, `"();\`) || value != strings.TrimSpace(value) {
+		// quote the string
+		return fmt.Sprint("\"", valueEscaper.Replace(value), "\"")
+	}
+
+	return value
 }
 
 func getSerial(serial string) (uint32, error) {
@@ -0,0 +1,685 @@
+package updater_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"zoneupdated/config"
+	"zoneupdated/updater"
+)
+
+func TestUpdater_Update(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	u := updater.New(config.Config{ZoneFileName: zoneFile, SequentialSerial: true})
+
+	result, err := u.Update(context.TODO(), updater.UpdateRequest{FQDN: "test", RRType: "A", Value: "192.0.2.2"})
+	if err != nil {
+		t.Fatalf("Update failed: %s", err)
+	}
+	if !result.Changed || result.Serial != 2020053002 {
+		t.Errorf("Expected change with serial 2020053002 but got %+v", result)
+	}
+
+	contents := readFile(t, zoneFile)
+	if !strings.Contains(contents, "test\t\t\tIN A\t\t192.0.2.2\n") {
+		t.Errorf("Zone file was not updated:\n%s", contents)
+	}
+
+	// Records of other types, and names that start with the same characters, should be unaffected
+	if !strings.Contains(contents, "test\t\t\tIN AAAA\t\t2001:db8::1\n") ||
+		!strings.Contains(contents, "test2\t\t\tIN A\t\t192.0.2.3\n") {
+		t.Errorf("Zone file has unexpected changes:\n%s", contents)
+	}
+
+	result, err = u.Update(context.TODO(), updater.UpdateRequest{FQDN: "test", RRType: "A", Value: "192.0.2.2"})
+	if err != nil {
+		t.Fatalf("Update failed: %s", err)
+	}
+	if result.Changed || result.Serial != 2020053002 {
+		t.Errorf("Expected no change with serial 2020053002 but got %+v", result)
+	}
+}
+
+func TestUpdater_UpdateHash(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	u := updater.New(config.Config{ZoneFileName: zoneFile, SequentialSerial: true})
+
+	// VFFI7ZOMWGN2MHCMBBZ5HEPJQ6MC7O6T is the hash of "test"
+	result, err := u.Update(context.TODO(), updater.UpdateRequest{FQDN: "test", RRType: "TXT", Value: "bar"})
+	if err != nil {
+		t.Fatalf("Update failed: %s", err)
+	}
+	if !result.Changed {
+		t.Errorf("Expected change but got %+v", result)
+	}
+
+	contents := readFile(t, zoneFile)
+	if !strings.Contains(contents, "\nVFFI7ZOMWGN2MHCMBBZ5HEPJQ6MC7O6T\tIN TXT bar\n") {
+		t.Errorf("Hashed record was not updated and enabled:\n%s", contents)
+	}
+}
+
+func TestUpdater_NotFound(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	u := updater.New(config.Config{ZoneFileName: zoneFile, SequentialSerial: true})
+
+	_, err := u.Update(context.TODO(), updater.UpdateRequest{FQDN: "_acme-challenge.example.com", RRType: "TXT",
+		Value: "new value"})
+	if err == nil {
+		t.Error("Update of unknown name should have failed")
+	}
+
+	_, err = u.Update(context.TODO(), updater.UpdateRequest{FQDN: "test", RRType: "MX", Value: "10 mail"})
+	if err == nil {
+		t.Error("Update of wrong record type should have failed")
+	}
+}
+
+func TestUpdater_UpdateKeep(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	u := updater.New(config.Config{ZoneFileName: zoneFile, SequentialSerial: true})
+
+	_, err := u.AppendRecords(context.TODO(), []updater.Record{updater.Placeholder("multi", "TXT"),
+		updater.Placeholder("multi", "TXT")})
+	if err != nil {
+		t.Fatalf("Appending placeholders failed: %s", err)
+	}
+
+	for _, value := range []string{"one", "two", "two", "three"} {
+		_, err = u.Update(context.TODO(), updater.UpdateRequest{FQDN: "multi", RRType: "TXT", Value: value, Keep: 2})
+		if err != nil {
+			t.Fatalf("Update failed: %s", err)
+		}
+	}
+
+	zone, err := u.Lookup(context.TODO(), "multi", "TXT")
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(zone.Records) != 2 || zone.Records[0].Value != "three" || zone.Records[1].Value != "two" ||
+		!zone.Records[0].Enabled || !zone.Records[1].Enabled {
+		t.Errorf("Expected the two most recent values but got %+v", zone.Records)
+	}
+}
+
+func TestUpdater_DryRun(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	original := readFile(t, zoneFile)
+	u := updater.New(config.Config{ZoneFileName: zoneFile, SequentialSerial: true})
+
+	result, err := u.Update(context.TODO(), updater.UpdateRequest{FQDN: "test", RRType: "A", Value: "192.0.2.2",
+		DryRun: true})
+	if err != nil {
+		t.Fatalf("Dry run failed: %s", err)
+	}
+	if !result.Changed || result.Serial != 2020053002 {
+		t.Errorf("Expected change with serial 2020053002 but got %+v", result)
+	}
+
+	for _, expected := range []string{"-\t\t\t2020053001\t; serial\n", "+\t\t\t2020053002\t; serial\n",
+		"-test\t\t\tIN A\t\t192.0.2.1\n", "+test\t\t\tIN A\t\t192.0.2.2\n"} {
+		if !strings.Contains(result.Diff, expected) {
+			t.Errorf("Expected diff to contain '%s' but got:\n%s", expected, result.Diff)
+		}
+	}
+
+	if readFile(t, zoneFile) != original {
+		t.Error("Dry run modified the zone file")
+	}
+	checkFileDoesNotExist(t, zoneFile+".tmp")
+
+	result, err = u.Update(context.TODO(), updater.UpdateRequest{FQDN: "test", RRType: "A", Value: "192.0.2.1",
+		DryRun: true})
+	if err != nil {
+		t.Fatalf("Dry run failed: %s", err)
+	}
+	if result.Changed || result.Serial != 2020053001 || result.Diff != "" {
+		t.Errorf("Expected no change with serial 2020053001 but got %+v", result)
+	}
+}
+
+func TestUpdater_Zone(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	u := updater.New(config.Config{ZoneFileName: zoneFile})
+
+	zone, err := u.Zone(context.TODO())
+	if err != nil {
+		t.Fatalf("Reading zone failed: %s", err)
+	}
+
+	if zone.Serial != 2020053001 {
+		t.Errorf("Expected serial 2020053001 but got %d", zone.Serial)
+	}
+
+	expected := []updater.Record{
+		{Name: "@", TTL: 60, RRType: "SOA", Line: 2, Enabled: true,
+			Value: "ns01.example.com.\thostmaster.example.com. ( 2020053001 3H 1H 7D 1M)"},
+		{Name: "@", TTL: 60, RRType: "NS", Value: "ns01.example.com.", Enabled: true, Line: 8},
+		{Name: "@", TTL: 60, RRType: "NS", Value: "ns02.example.com.", Enabled: true, Line: 9},
+		{Name: "_acme-challenge.dyn.example.com", TTL: 60, RRType: "TXT", Value: "JDG7FDdhb", Enabled: true, Line: 11},
+		{Name: "test", TTL: 60, RRType: "A", Value: "192.0.2.1", Enabled: true, Line: 12},
+		{Name: "VFFI7ZOMWGN2MHCMBBZ5HEPJQ6MC7O6T", TTL: 60, RRType: "TXT", Value: "foo", Enabled: false, Line: 13},
+		{Name: "test", TTL: 60, RRType: "AAAA", Value: "2001:db8::1", Enabled: true, Line: 14},
+		{Name: "test2", TTL: 60, RRType: "A", Value: "192.0.2.3", Enabled: true, Line: 15},
+	}
+
+	if len(zone.Records) != len(expected) {
+		t.Fatalf("Expected %d records but got %d: %+v", len(expected), len(zone.Records), zone.Records)
+	}
+	for i, record := range zone.Records {
+		if record != expected[i] {
+			t.Errorf("Expected record %+v but got %+v", expected[i], record)
+		}
+	}
+}
+
+func TestUpdater_ZoneComments(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	comments := "; test IN A 192.0.2.50\n\t;test IN A 192.0.2.51\n;test\tIN A\t192.0.2.52\n"
+	if err := ioutil.WriteFile(zoneFile, []byte(readFile(t, zoneFile)+comments), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	u := updater.New(config.Config{ZoneFileName: zoneFile})
+
+	// Only the record disabled the way the updater writes them is one
+	zone, err := u.Zone(context.TODO())
+	if err != nil {
+		t.Fatalf("Reading zone failed: %s", err)
+	}
+	rrset := zone.RRset("test", "A")
+	if len(rrset) != 2 || rrset[1].Value != "192.0.2.52" || rrset[1].Enabled {
+		t.Errorf("Unexpected records %+v", rrset)
+	}
+
+	_, err = u.Update(context.TODO(), updater.UpdateRequest{FQDN: "test", RRType: "A", Value: "192.0.2.9"})
+	if err != nil {
+		t.Fatalf("Update failed: %s", err)
+	}
+	if contents := readFile(t, zoneFile); !strings.Contains(contents, comments[:strings.LastIndex(comments[:len(comments)-1], "\n")+1]) {
+		t.Errorf("Comments should have been left alone:\n%s", contents)
+	}
+}
+
+func TestUpdater_Lookup(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	u := updater.New(config.Config{ZoneFileName: zoneFile})
+
+	zone, err := u.Lookup(context.TODO(), "test", "txt")
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(zone.Records) != 1 || zone.Records[0].Name != "VFFI7ZOMWGN2MHCMBBZ5HEPJQ6MC7O6T" || zone.Records[0].Enabled {
+		t.Errorf("Lookup should have found the disabled hashed record but got %+v", zone.Records)
+	}
+
+	_, err = u.Lookup(context.TODO(), "nosuchname", "TXT")
+	if err == nil {
+		t.Error("Lookup of unknown name should have failed")
+	}
+}
+
+func TestUpdater_Resolve(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	u := updater.New(config.Config{ZoneFileName: zoneFile})
+
+	name, err := u.Resolve(context.TODO(), []string{"test.example.com", "test.example.com.", "test"}, "A")
+	if err != nil {
+		t.Fatalf("Resolve failed: %s", err)
+	}
+	if name != "test" {
+		t.Errorf("Resolve should have found test but got %s", name)
+	}
+
+	_, err = u.Resolve(context.TODO(), []string{"nosuchname", "nosuchname."}, "A")
+	if !errors.Is(err, updater.ErrNotFound) {
+		t.Errorf("Resolve of unknown names should have failed with not found but got %v", err)
+	}
+}
+
+func TestUpdater_LookupMatchesUpdate(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	// Updates don't match records with the class before the TTL, or the name
+	// left out, so neither does Lookup
+	contents := readFile(t, zoneFile) + "test\t\tIN 300 A\t192.0.2.8\n\t\t\tIN A\t192.0.2.9\n"
+	if err := ioutil.WriteFile(zoneFile, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	u := updater.New(config.Config{ZoneFileName: zoneFile})
+
+	zone, err := u.Lookup(context.TODO(), "TEST", "A")
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+
+	result, err := u.Update(context.TODO(), updater.UpdateRequest{FQDN: "TEST", RRType: "A", Value: "192.0.2.2", DryRun: true})
+	if err != nil {
+		t.Fatalf("Update failed: %s", err)
+	}
+
+	if len(zone.Records) != len(result.Lines) {
+		t.Fatalf("Lookup found %+v but the update matched lines %v", zone.Records, result.Lines)
+	}
+	for i, record := range zone.Records {
+		if record.Line != result.Lines[i] {
+			t.Errorf("Lookup found %+v but the update matched lines %v", zone.Records, result.Lines)
+		}
+	}
+}
+
+func TestUpdater_Delegations(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	contents := readFile(t, zoneFile) + ";SXWXORGCA5X4QPKMPDZD66FWUW4RYFD7\tIN TXT\tplaceholder\n"
+	if err := ioutil.WriteFile(zoneFile, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	u := updater.New(config.Config{ZoneFileName: zoneFile, ZoneName: "dyn.example.com", SequentialSerial: true})
+
+	fqdns := []string{"_acme-challenge.example.com", "test"}
+	delegations, result, err := u.Delegations(context.TODO(), fqdns, "TXT", true)
+	if err != nil {
+		t.Fatalf("Delegations failed: %s", err)
+	}
+	if !result.Changed || result.Serial != 2020053002 {
+		t.Errorf("Expected change with serial 2020053002 but got %+v", result)
+	}
+
+	// Names are qualified with a trailing dot for the CNAME and the hash, and
+	// the zone already has a placeholder for test.
+	expected := []updater.Delegation{
+		{
+			FQDN:        "_acme-challenge.example.com",
+			Label:       "LUTNAGC6V6JJZWC6DRCJVUBVFL6D6K2M",
+			CNAME:       "_acme-challenge.example.com.\tIN CNAME\tLUTNAGC6V6JJZWC6DRCJVUBVFL6D6K2M.dyn.example.com.",
+			Placeholder: ";LUTNAGC6V6JJZWC6DRCJVUBVFL6D6K2M\tIN TXT\tplaceholder",
+			Appended:    true,
+		},
+		{
+			FQDN:        "test",
+			Label:       "SXWXORGCA5X4QPKMPDZD66FWUW4RYFD7",
+			CNAME:       "test.\tIN CNAME\tSXWXORGCA5X4QPKMPDZD66FWUW4RYFD7.dyn.example.com.",
+			Placeholder: ";SXWXORGCA5X4QPKMPDZD66FWUW4RYFD7\tIN TXT\tplaceholder",
+			Exists:      true,
+		},
+	}
+	if len(delegations) != len(expected) {
+		t.Fatalf("Expected %d delegations but got %+v", len(expected), delegations)
+	}
+	for i, delegation := range delegations {
+		if delegation != expected[i] {
+			t.Errorf("Expected delegation %+v but got %+v", expected[i], delegation)
+		}
+	}
+
+	if !strings.HasSuffix(readFile(t, zoneFile), "\n;LUTNAGC6V6JJZWC6DRCJVUBVFL6D6K2M\tIN TXT\tplaceholder\n") {
+		t.Errorf("Placeholder was not appended to the zone file")
+	}
+
+	// The label is the same with the trailing dot
+	delegations, _, err = u.Delegations(context.TODO(), []string{"_acme-challenge.example.com."}, "TXT", false)
+	if err != nil || len(delegations) != 1 || delegations[0].Label != expected[0].Label || !delegations[0].Exists {
+		t.Errorf("Unexpected delegation %+v %v", delegations, err)
+	}
+
+	// The placeholder can now be updated, with the name written either way
+	for _, fqdn := range []string{"_acme-challenge.example.com.", "_acme-challenge.example.com"} {
+		_, err = u.Update(context.TODO(), updater.UpdateRequest{FQDN: fqdn, RRType: "TXT", Value: fqdn})
+		if err != nil {
+			t.Errorf("Update of appended placeholder for %s failed: %s", fqdn, err)
+		}
+	}
+}
+
+func tempZoneFile(t *testing.T) string {
+	zoneFile := fmt.Sprintf("%s%c%d.%s.zone", os.TempDir(), os.PathSeparator, os.Getpid(), t.Name())
+
+	source, err := os.Open("testdata/test.zone")
+	if err != nil {
+		t.Fatalf("Unable to open test zone: %s", err)
+	}
+	defer source.Close()
+
+	dest, err := os.Create(zoneFile)
+	if err != nil {
+		t.Fatalf("Unable to create temporary zone file %s: %s", zoneFile, err)
+	}
+	defer dest.Close()
+
+	_, err = io.Copy(dest, source)
+	if err != nil {
+		t.Fatalf("Unable to copy test zone: %s", err)
+	}
+
+	return zoneFile
+}
+
+func removeZoneFile(zoneFile string) {
+	_ = os.Remove(zoneFile)
+	_ = os.Remove(zoneFile + ".lock")
+}
+
+func readFile(t *testing.T, filename string) string {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", filename, err)
+	}
+
+	return string(data)
+}
+
+func checkFileDoesNotExist(t *testing.T, filename string) {
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("File %s exists but is expected not to", filename)
+	}
+}
+
+func TestUpdater_ApplyChanges(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	u := updater.New(config.Config{ZoneFileName: zoneFile, ZoneName: "dyn.example.com", SequentialSerial: true})
+
+	changes := updater.Changes{
+		Delete: []updater.Record{{Name: "test.dyn.example.com.", RRType: "A", Value: "192.0.2.1"}},
+		Create: []updater.Record{
+			{Name: "test.dyn.example.com.", RRType: "A", Value: "192.0.2.9"},
+			{Name: "test2", RRType: "A", Value: "192.0.2.3"},
+		},
+	}
+
+	result, err := u.ApplyChanges(context.TODO(), changes, nil)
+	if err != nil {
+		t.Fatalf("Applying changes failed: %s", err)
+	}
+	if !result.Changed || result.Serial != 2020053002 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+
+	zone, err := u.Lookup(context.TODO(), "test", "A")
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	// test2 already existed, so isn't added again
+	if len(zone.Records) != 1 || zone.Records[0].Value != "192.0.2.9" || zone.Records[0].Line != 15 {
+		t.Errorf("Unexpected records after changes %+v", zone.Records)
+	}
+
+	// Nothing is changed if any record to delete is missing, or the check fails
+	_, err = u.ApplyChanges(context.TODO(), updater.Changes{
+		Delete: []updater.Record{{Name: "test", RRType: "A", Value: "192.0.2.9"}, {Name: "test", RRType: "A", Value: "192.0.2.1"}},
+	}, nil)
+	if !errors.Is(err, updater.ErrNotFound) {
+		t.Errorf("Expected not found error but got %v", err)
+	}
+
+	refused := errors.New("refused")
+	_, err = u.ApplyChanges(context.TODO(), changes, func(updater.Zone) error { return refused })
+	if err != refused {
+		t.Errorf("Expected check to refuse changes but got %v", err)
+	}
+
+	zone, err = u.Zone(context.TODO())
+	if err != nil {
+		t.Fatalf("Reading zone failed: %s", err)
+	}
+	if zone.Serial != 2020053002 {
+		t.Errorf("Expected serial 2020053002 but got %d", zone.Serial)
+	}
+}
+
+func TestUpdater_EditRRset(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	u := updater.New(config.Config{ZoneFileName: zoneFile, ZoneName: "dyn.example.com", SequentialSerial: true})
+
+	set := func(values ...string) updater.RRsetEdit {
+		return func(zone updater.Zone, current []updater.Record) ([]updater.Record, error) {
+			var records []updater.Record
+			for _, value := range values {
+				records = append(records, updater.Record{Value: value, Enabled: true})
+			}
+			return records, nil
+		}
+	}
+
+	result, edited, err := u.EditRRset(context.TODO(), "test.dyn.example.com.", "A", set("192.0.2.1", "192.0.2.2"))
+	if err != nil {
+		t.Fatalf("Editing RRset failed: %s", err)
+	}
+	records := edited.RRset("test", "A")
+	if !result.Changed || edited.Serial != result.Serial || len(records) != 2 || records[0].Name != "test" ||
+		records[1].Value != "192.0.2.2" {
+		t.Errorf("Unexpected result %+v %+v", result, edited)
+	}
+
+	// The unchanged record is left as it was, and the new one follows it
+	lines := strings.Split(readFile(t, zoneFile), "\n")
+	if lines[11] != "test\t\t\tIN A\t\t192.0.2.1" || lines[12] != "test\tIN A\t192.0.2.2" {
+		t.Errorf("Unexpected zone file after edit:\n%s", strings.Join(lines, "\n"))
+	}
+
+	result, _, err = u.EditRRset(context.TODO(), "test", "A", set("192.0.2.1", "192.0.2.2"))
+	if err != nil || result.Changed {
+		t.Errorf("Setting the same records should change nothing, got %+v %v", result, err)
+	}
+
+	result, _, err = u.EditRRset(context.TODO(), "test", "A", set())
+	if err != nil || !result.Changed || result.Serial != 2020053003 {
+		t.Errorf("Unexpected result of deleting RRset %+v %v", result, err)
+	}
+
+	zone, err := u.Zone(context.TODO())
+	if err != nil {
+		t.Fatalf("Reading zone failed: %s", err)
+	}
+	if rrset := zone.RRset("test", "A"); len(rrset) != 0 {
+		t.Errorf("Expected RRset to be deleted but found %+v", rrset)
+	}
+	if rrset := zone.RRset("test2.dyn.example.com.", "a"); len(rrset) != 1 || rrset[0].Value != "192.0.2.3" {
+		t.Errorf("Unexpected test2 RRset %+v", rrset)
+	}
+}
+
+func TestUpdater_EditRRsetMultiLine(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	multi := "multi\tIN TXT\t( \"part one\" ; first\n\t\"part two\" )\n"
+	if err := ioutil.WriteFile(zoneFile, []byte(readFile(t, zoneFile)+multi), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	u := updater.New(config.Config{ZoneFileName: zoneFile, ZoneName: "dyn.example.com", SequentialSerial: true})
+
+	set := func(values ...string) updater.RRsetEdit {
+		return func(zone updater.Zone, current []updater.Record) ([]updater.Record, error) {
+			var records []updater.Record
+			for _, value := range values {
+				records = append(records, updater.Record{Value: value, Enabled: true})
+			}
+			return records, nil
+		}
+	}
+
+	// An unchanged record keeps all of its lines
+	value := `( "part one" "part two" )`
+	_, _, err := u.EditRRset(context.TODO(), "multi", "TXT", set(value, "three"))
+	if err != nil {
+		t.Fatalf("Editing RRset failed: %s", err)
+	}
+	if contents := readFile(t, zoneFile); !strings.HasSuffix(contents, multi+"multi\tIN TXT\tthree\n") {
+		t.Errorf("Unexpected zone file after edit:\n%s", contents)
+	}
+
+	// A replaced one loses all of them
+	_, _, err = u.EditRRset(context.TODO(), "multi", "TXT", set("new"))
+	if err != nil {
+		t.Fatalf("Editing RRset failed: %s", err)
+	}
+	contents := readFile(t, zoneFile)
+	if strings.Contains(contents, "part") || !strings.HasSuffix(contents, "\nmulti\tIN TXT\tnew\n") {
+		t.Errorf("Unexpected zone file after edit:\n%s", contents)
+	}
+
+	zone, err := u.Zone(context.TODO())
+	if err != nil {
+		t.Fatalf("Reading zone failed: %s", err)
+	}
+	if rrset := zone.RRset("multi", "TXT"); len(rrset) != 1 || rrset[0].Value != "new" {
+		t.Errorf("Unexpected RRset %+v", rrset)
+	}
+}
+
+func TestUpdater_UpdateMultiLine(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	multi := "multi\tIN TXT\t( \"part one\" ; first\n\t\"part two\" )\n"
+	if err := ioutil.WriteFile(zoneFile, []byte(readFile(t, zoneFile)+multi), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	u := updater.New(config.Config{ZoneFileName: zoneFile, ZoneName: "dyn.example.com", SequentialSerial: true})
+
+	// Updates replace records in the same way as RRset edits, lines and all
+	result, err := u.Update(context.TODO(), updater.UpdateRequest{FQDN: "multi", RRType: "TXT", Value: "new"})
+	if err != nil {
+		t.Fatalf("Update failed: %s", err)
+	}
+	if len(result.Matched) != 1 || result.Matched[0] != "multi\tIN TXT\tnew" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	contents := readFile(t, zoneFile)
+	if strings.Contains(contents, "part") || !strings.HasSuffix(contents, "\nmulti\tIN TXT\tnew\n") {
+		t.Errorf("Unexpected zone file after update:\n%s", contents)
+	}
+}
+
+func TestUpdater_SpecialValues(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	u := updater.New(config.Config{ZoneFileName: zoneFile, ZoneName: "dyn.example.com", SequentialSerial: true})
+
+	values := []string{";", ";x", "(", ")", `a\b`, ` padded `, `say "hi"`, `\"(;`}
+	_, _, err := u.EditRRset(context.TODO(), "special", "TXT",
+		func(zone updater.Zone, current []updater.Record) ([]updater.Record, error) {
+			var records []updater.Record
+			for _, value := range values {
+				records = append(records, updater.Record{Value: value, Enabled: true})
+			}
+			return records, nil
+		})
+	if err != nil {
+		t.Fatalf("Editing RRset failed: %s", err)
+	}
+
+	// Through the update of a single record too
+	_, err = u.Update(context.TODO(), updater.UpdateRequest{FQDN: "test", RRType: "A", Value: "("})
+	if err != nil {
+		t.Fatalf("Update failed: %s", err)
+	}
+
+	zone, err := u.Zone(context.TODO())
+	if err != nil {
+		t.Fatalf("Reading zone failed: %s", err)
+	}
+	rrset := zone.RRset("special", "TXT")
+	if len(rrset) != len(values) {
+		t.Fatalf("Expected %d records but found %+v in:\n%s", len(values), rrset, readFile(t, zoneFile))
+	}
+	for i, record := range rrset {
+		if record.Value != values[i] {
+			t.Errorf("Expected value %q but got %q", values[i], record.Value)
+		}
+	}
+	if rrset := zone.RRset("test", "A"); len(rrset) != 1 || rrset[0].Value != "(" {
+		t.Errorf("Unexpected test RRset %+v", rrset)
+	}
+
+	// The records after them are still there
+	if rrset := zone.RRset("test2", "A"); len(rrset) != 1 || rrset[0].Value != "192.0.2.3" {
+		t.Errorf("Unexpected test2 RRset %+v in:\n%s", rrset, readFile(t, zoneFile))
+	}
+	if zone.Serial != 2020053003 {
+		t.Errorf("Unexpected serial %d", zone.Serial)
+	}
+}
+
+func TestUpdater_Preconditions(t *testing.T) {
+	zoneFile := tempZoneFile(t)
+	defer removeZoneFile(zoneFile)
+
+	u := updater.New(config.Config{ZoneFileName: zoneFile, SequentialSerial: true})
+
+	value := func(s string) *string { return &s }
+	enabled := func(b bool) *bool { return &b }
+	serial := func(n uint32) *uint32 { return &n }
+
+	failing := []updater.UpdateRequest{
+		{FQDN: "test", RRType: "A", Value: "192.0.2.2", ExpectValue: value("192.0.2.9")},
+		{FQDN: "test", RRType: "A", Value: "192.0.2.2", ExpectAbsent: true},
+		{FQDN: "test", RRType: "A", Value: "192.0.2.2", ExpectEnabled: enabled(false)},
+		{FQDN: "test", RRType: "A", Value: "192.0.2.2", IfSerial: serial(2020053000)},
+		{FQDN: "test", RRType: "TXT", Value: "x", ExpectEnabled: enabled(true)},
+	}
+
+	for _, request := range failing {
+		_, err := u.Update(context.TODO(), request)
+
+		var precondition *updater.PreconditionError
+		if !errors.As(err, &precondition) {
+			t.Errorf("Expected precondition to fail for %+v but got %v", request, err)
+			continue
+		}
+		if precondition.Serial != 2020053001 || len(precondition.Records) != 1 {
+			t.Errorf("Unexpected current state %+v", precondition)
+		}
+	}
+
+	if readFile(t, zoneFile) != readFile(t, "testdata/test.zone") {
+		t.Error("Zone file was changed by updates whose preconditions failed")
+	}
+
+	result, err := u.Update(context.TODO(), updater.UpdateRequest{FQDN: "test", RRType: "A", Value: "192.0.2.2",
+		ExpectValue: value("192.0.2.1"), ExpectEnabled: enabled(true), IfSerial: serial(2020053001)})
+	if err != nil || !result.Changed {
+		t.Errorf("Expected update to be made but got %+v %v", result, err)
+	}
+
+	// A disabled record is absent
+	_, err = u.Update(context.TODO(), updater.UpdateRequest{FQDN: "test", RRType: "TXT", Value: "x", ExpectAbsent: true})
+	if err != nil {
+		t.Errorf("Expected update of disabled record to be made but got %v", err)
+	}
+}
@@ -0,0 +1,333 @@
+package updater
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Record is a resource record found in the zone file. Records that are commented
+// out are included but not Enabled.
+type Record struct {
+	Name    string `json:"name"`
+	TTL     uint32 `json:"ttl"`
+	RRType  string `json:"rrtype"`
+	Value   string `json:"value"`
+	Enabled bool   `json:"enabled"`
+	Line    int    `json:"line"`
+}
+
+type Zone struct {
+	Name    string   `json:"zone"`
+	Serial  uint32   `json:"serial"`
+	Records []Record `json:"records"`
+}
+
+var (
+	knownRRTypes = map[string]bool{
+		"A": true, "AAAA": true, "AFSDB": true, "CAA": true, "CERT": true, "CNAME": true, "DNAME": true,
+		"DNSKEY": true, "DS": true, "HINFO": true, "HTTPS": true, "LOC": true, "MX": true, "NAPTR": true,
+		"NS": true, "PTR": true, "RP": true, "SOA": true, "SPF": true, "SRV": true, "SSHFP": true,
+		"SVCB": true, "TLSA": true, "TXT": true, "URI": true,
+	}
+	classes       = map[string]bool{"IN": true, "CH": true, "HS": true, "CS": true}
+	genericRRType = regexp.MustCompile("^TYPE\\d+$")
+	ttlMatcher    = regexp.MustCompile("(?i)^(\\d+[smhdw]?)+$")
+	ttlPart       = regexp.MustCompile("(?i)(\\d+)([smhdw]?)")
+	fieldMatcher  = regexp.MustCompile("\\S+")
+)
+
+// parseZone reads the records from a zone file. It understands enough of the
+// zone file format to find the records zoneupdated can update, and the serial,
+// but it isn't a complete zone file parser.
+func (updater *Updater) parseZone(zoneFile io.Reader) (Zone, error) {
+	zone := Zone{Records: []Record{}}
+
+	var defaultTTL uint32
+	origin := ""
+	lastOwner := ""
+	lineNumber := 0
+
+	scanner := bufio.NewScanner(zoneFile)
+	scanner.Split(bufio.ScanLines)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNumber++
+		recordLine := lineNumber
+
+		groups := updater.serialMatcher.FindStringSubmatch(line)
+		if groups != nil {
+			serial, err := getSerial(groups[2])
+			if err != nil {
+				return Zone{}, err
+			}
+			zone.Serial = serial
+		}
+
+		enabled := true
+		text := line
+		if strings.HasPrefix(line, ";") {
+			// Only records disabled the way the updater does it, with the ;
+			// right before the name, are records rather than comments
+			if len(line) == 1 || line[1] == ' ' || line[1] == '\t' {
+				continue
+			}
+			enabled = false
+			text = line[1:]
+		}
+
+		text = stripComment(text)
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "$") {
+			fields := strings.Fields(text)
+			if enabled && len(fields) > 1 && strings.EqualFold(fields[0], "$TTL") {
+				if ttl, ok := parseTTL(fields[1]); ok {
+					defaultTTL = ttl
+				}
+			} else if enabled && len(fields) > 1 && strings.EqualFold(fields[0], "$ORIGIN") && origin == "" {
+				origin = fields[1]
+			}
+			continue
+		}
+
+		// Join multi-line records (eg SOA) into one
+		for enabled && unbalanced(text) && scanner.Scan() {
+			line = scanner.Text()
+			lineNumber++
+
+			groups := updater.serialMatcher.FindStringSubmatch(line)
+			if groups != nil {
+				serial, err := getSerial(groups[2])
+				if err != nil {
+					return Zone{}, err
+				}
+				zone.Serial = serial
+			}
+
+			text = text + " " + strings.TrimSpace(stripComment(line))
+		}
+
+		record, ok := parseRecord(text, lastOwner)
+		if !ok {
+			continue
+		}
+
+		if record.Name != "" && enabled {
+			lastOwner = record.Name
+		}
+		if record.TTL == 0 {
+			record.TTL = defaultTTL
+		}
+		record.Enabled = enabled
+		record.Line = recordLine
+
+		zone.Records = append(zone.Records, record)
+	}
+
+	zone.Name = updater.zoneName(origin)
+
+	return zone, scanner.Err()
+}
+
+// zoneName is the configured name of the zone, or else the first $ORIGIN in the
+// zone file, or else the zone file's name. It always ends with a dot.
+func (updater *Updater) zoneName(origin string) string {
+	name := updater.conf.ZoneName
+	if name == "" {
+		name = origin
+	}
+	if name == "" {
+		name = filepath.Base(updater.conf.ZoneFileName)
+	}
+
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+
+	return name
+}
+
+func parseRecord(text string, lastOwner string) (Record, bool) {
+	record := Record{}
+
+	indices := fieldMatcher.FindAllStringIndex(text, -1)
+	if len(indices) == 0 {
+		return record, false
+	}
+
+	field := 0
+	if indices[0][0] == 0 {
+		record.Name = text[indices[0][0]:indices[0][1]]
+		field++
+	} else {
+		record.Name = lastOwner
+	}
+
+	haveTTL, haveClass := false, false
+	for ; field < len(indices); field++ {
+		token := text[indices[field][0]:indices[field][1]]
+		upper := strings.ToUpper(token)
+
+		if !haveTTL && ttlMatcher.MatchString(token) {
+			record.TTL, _ = parseTTL(token)
+			haveTTL = true
+		} else if !haveClass && classes[upper] {
+			haveClass = true
+		} else if knownRRTypes[upper] || genericRRType.MatchString(upper) {
+			record.RRType = upper
+			if field+1 >= len(indices) {
+				return record, false
+			}
+			record.Value = unquote(strings.TrimSpace(text[indices[field+1][0]:]))
+			return record, record.Name != ""
+		} else {
+			return record, false
+		}
+	}
+
+	return record, false
+}
+
+// stripComment removes a trailing comment, taking quoted strings into account.
+func stripComment(text string) string {
+	inQuotes := false
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuotes = !inQuotes
+		case ';':
+			if !inQuotes {
+				return strings.TrimRight(text[:i], " \t")
+			}
+		}
+	}
+
+	return text
+}
+
+// unbalanced checks whether text has more opening parentheses than closing
+// ones outside quoted strings, so that the record continues on the next line.
+func unbalanced(text string) bool {
+	inQuotes := false
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuotes = !inQuotes
+		case '(':
+			if !inQuotes {
+				depth++
+			}
+		case ')':
+			if !inQuotes {
+				depth--
+			}
+		}
+	}
+
+	return depth > 0
+}
+
+// unquote reverses the quoting done for values containing spaces or quotes.
+// Values made of multiple strings or other fields are returned unchanged.
+func unquote(value string) string {
+	if len(value) < 2 || !strings.HasPrefix(value, "\"") || !strings.HasSuffix(value, "\"") {
+		return value
+	}
+
+	var sb strings.Builder
+	inner := value[1 : len(value)-1]
+	for i := 0; i < len(inner); i++ {
+		switch inner[i] {
+		case '\\':
+			if i+1 < len(inner) {
+				i++
+			}
+		case '"':
+			// more than one string
+			return value
+		}
+		sb.WriteByte(inner[i])
+	}
+
+	return sb.String()
+}
+
+func parseTTL(ttl string) (uint32, bool) {
+	if !ttlMatcher.MatchString(ttl) {
+		return 0, false
+	}
+
+	units := map[string]uint64{"": 1, "s": 1, "m": 60, "h": 3600, "d": 86400, "w": 604800}
+
+	var total uint64
+	for _, part := range ttlPart.FindAllStringSubmatch(ttl, -1) {
+		n, err := strconv.ParseUint(part[1], 10, 32)
+		if err != nil {
+			return 0, false
+		}
+		total += n * units[strings.ToLower(part[2])]
+	}
+
+	return uint32(total), true
+}
+
+// IsType checks whether a record has type rrtype.
+func (record Record) IsType(rrtype string) bool {
+	return strings.EqualFold(record.RRType, rrtype)
+}
+
+// Matches checks whether a record has type rrtype and one of names, which are
+// usually an fqdn and its hash labels.
+func (record Record) Matches(rrtype string, names ...string) bool {
+	if !record.IsType(rrtype) {
+		return false
+	}
+
+	for _, name := range names {
+		if strings.EqualFold(record.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FQDN returns name fully qualified, treating names without a trailing dot as
+// relative to the zone, as the zone file format does.
+func (zone Zone) FQDN(name string) string {
+	if name == "@" {
+		return zone.Name
+	}
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+
+	return name + "." + zone.Name
+}
+
+// RelativeName returns the shortest way of writing name in the zone file.
+func (zone Zone) RelativeName(name string) string {
+	fqdn := zone.FQDN(name)
+	if strings.EqualFold(fqdn, zone.Name) {
+		return "@"
+	}
+
+	suffix := "." + zone.Name
+	if len(fqdn) > len(suffix) && strings.EqualFold(fqdn[len(fqdn)-len(suffix):], suffix) {
+		return fqdn[:len(fqdn)-len(suffix)]
+	}
+
+	return fqdn
+}